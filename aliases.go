@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Aliases are other names for a page.  Each is a file in the aliases folder
+// named after the alias that holds the title it points at.  Moving a page
+// leaves one behind under the old name so bookmarks keep working.
+var aliasDir string
+
+var validAlias = regexp.MustCompile(`^[a-zA-Z0-9\.\-_ /]+$`)
+
+func getWikiAliasFilename(name string) string {
+	return aliasDir + name
+}
+
+// getAlias gives the title an alias points at, or "" if there isn't one
+func (fst *fileStorage) getAlias(name string) string {
+	target, err := os.ReadFile(getWikiAliasFilename(name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(target))
+}
+
+// getAliases lists the aliases that point at a page
+func (fst *fileStorage) getAliases(title string) []string {
+	return append([]string{}, indexAliases(aliasDir)[title]...)
+}
+
+// indexAliases reads every alias under root, giving the aliases that point
+// at each page in order
+func indexAliases(root string) map[string][]string {
+	aliases := map[string][]string{}
+	if root == "" {
+		return aliases
+	}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		target, err := os.ReadFile(path)
+		if err == nil {
+			title := strings.TrimSpace(string(target))
+			aliases[title] = append(aliases[title], strings.TrimPrefix(path, root))
+		}
+		return nil
+	})
+	for _, a := range aliases {
+		sort.Strings(a)
+	}
+	return aliases
+}
+
+// parseAliases splits a comma separated list of aliases for title, checking
+// each is usable as a page name and isn't already a page
+func parseAliases(s storage, title, list string) ([]string, error) {
+	aliases := []string{}
+	for _, a := range strings.Split(list, ",") {
+		a = strings.Trim(strings.TrimSpace(a), "/")
+		if a == "" || a == title || contains(a, aliases) {
+			continue
+		}
+		if !validAlias.MatchString(a) || contains("..", strings.Split(a, "/")) {
+			return nil, fmt.Errorf("%q can't be used as an alias", a)
+		}
+		if _, err := s.getPage(&wikiPage{basePage: basePage{Title: a}}); err == nil {
+			return nil, fmt.Errorf("%q is already a page", a)
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, nil
+}
+
+// setAliases makes aliases the full list of aliases for a page
+func setAliases(s storage, title string, aliases []string) error {
+	for _, a := range s.getAliases(title) {
+		if !contains(a, aliases) {
+			if err := s.deleteFile(getWikiAliasFilename(a)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for _, a := range aliases {
+		if s.getAlias(a) == title {
+			continue
+		}
+		if err := s.storeFile(getWikiAliasFilename(a), []byte(title)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveAliases leaves an alias from the old name of a moved page to its new
+// one.  Aliases that pointed at the old name are pointed straight at the
+// new one so they don't chain, and any alias that was using the new name
+// goes as there's now a real page there.
+func moveAliases(s storage, from, to string) error {
+	for _, a := range append(s.getAliases(from), from) {
+		if a == to {
+			continue
+		}
+		if err := s.storeFile(getWikiAliasFilename(a), []byte(to)); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteFile(getWikiAliasFilename(to)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
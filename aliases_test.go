@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func postAliases(s storage, title, aliases string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Add("body", "body of "+title)
+	form.Add("aliases", aliases)
+	req := httptest.NewRequest("POST", "http://localhost/wiki/save/"+title, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	saveHandler(w, req, title, s)
+	return w
+}
+
+func TestMoveLeavesAlias(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	(&wikiPage{basePage: basePage{Title: "first"}, Body: "body", Published: true}).save(&fs)
+	if _, err := movePage(&fs, "first", "second", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := movePage(&fs, "second", "folder/third", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both old names go straight to the latest
+	for _, old := range []string{"first", "second"} {
+		if to := fs.getAlias(old); to != "folder/third" {
+			t.Errorf("Expected %v to alias folder/third but got %q", old, to)
+		}
+	}
+	if aliases := fs.getAliases("folder/third"); !reflect.DeepEqual(aliases, []string{"first", "second"}) {
+		t.Errorf("Expected first and second as aliases but got %v", aliases)
+	}
+
+	// Moving back onto an old name drops the alias it had
+	if _, err := movePage(&fs, "folder/third", "first", false); err != nil {
+		t.Fatal(err)
+	}
+	if to := fs.getAlias("first"); to != "" {
+		t.Errorf("Expected no alias for a real page but got %q", to)
+	}
+	if to := fs.getAlias("folder/third"); to != "first" {
+		t.Errorf("Expected folder/third to alias first but got %q", to)
+	}
+
+	for _, c := range []struct{ path, location string }{
+		{"/wiki/view/second", "/wiki/view/first"},
+		{"/pub/second", "/pub/first"},
+	} {
+		req := httptest.NewRequest("GET", "http://localhost"+c.path, nil)
+		w := httptest.NewRecorder()
+		p := &wikiPage{basePage: basePage{Title: "second"}}
+		if strings.HasPrefix(c.path, "/pub/") {
+			pubHandler(w, req, p, &fs)
+		} else {
+			viewHandler(w, req, p, &fs)
+		}
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != c.location {
+			t.Errorf("Expected %v to redirect to %v but got %v %v", c.path, c.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestPubAliasToUnpublishedPage(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	(&wikiPage{basePage: basePage{Title: "private"}, Body: "body"}).save(&fs)
+	movePage(&fs, "private", "moved", false)
+
+	req := httptest.NewRequest("GET", "http://localhost/pub/private", nil)
+	w := httptest.NewRecorder()
+	pubHandler(w, req, &wikiPage{basePage: basePage{Title: "private"}}, &fs)
+	if w.Code == http.StatusMovedPermanently {
+		t.Error("Published alias should not point at an unpublished page")
+	}
+}
+
+func TestSaveHandlerAliases(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	(&wikiPage{basePage: basePage{Title: "other"}, Body: "body"}).save(&fs)
+
+	if w := postAliases(&fs, "page", " nickname , folder/nick, page,"); w.Code != http.StatusFound {
+		t.Fatalf("Expected a 302 response, got %v: %v", w.Code, w.Body.String())
+	}
+	if aliases := fs.getAliases("page"); !reflect.DeepEqual(aliases, []string{"folder/nick", "nickname"}) {
+		t.Errorf("Expected both aliases but got %v", aliases)
+	}
+
+	if w := postAliases(&fs, "page", "nickname"); w.Code != http.StatusFound {
+		t.Fatalf("Expected a 302 response, got %v", w.Code)
+	}
+	if aliases := fs.getAliases("page"); !reflect.DeepEqual(aliases, []string{"nickname"}) {
+		t.Errorf("Expected the removed alias to go but got %v", aliases)
+	}
+
+	for _, bad := range []string{"other", "../escape", "bad*name"} {
+		if w := postAliases(&fs, "page", bad); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for alias %q but got %v", bad, w.Code)
+		}
+	}
+	if aliases := fs.getAliases("page"); !reflect.DeepEqual(aliases, []string{"nickname"}) {
+		t.Errorf("Expected rejected aliases to leave things alone but got %v", aliases)
+	}
+}
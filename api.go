@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func apiHandler(fn func(http.ResponseWriter, *http.Request, storage), s storage) http.HandlerFunc {
@@ -71,6 +75,27 @@ func handlePostWiki(w http.ResponseWriter, r *http.Request, s storage) bool {
 
 	// TODO: Handle encryption and published pages
 
+	defer lockPage(wp.Title)()
+	if wp.Version != "" {
+		if current, same := checkVersion(s, wp.Title, wp.Version); !same {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(current)
+			return true
+		}
+	}
+
+	wp.Author = requestAuthor(r)
+
+	// Keep the front matter the request doesn't mention, as the edit form does
+	if current, err := s.getPage(&wikiPage{basePage: basePage{Title: wp.Title}}); err == nil {
+		if wp.Created == "" {
+			wp.Created = current.Created
+		}
+		if wp.Meta == nil {
+			wp.Meta = current.Meta
+		}
+	}
+
 	err = wp.save(s)
 	if err != nil {
 		log.Print(err)
@@ -81,6 +106,309 @@ func handlePostWiki(w http.ResponseWriter, r *http.Request, s storage) bool {
 	w.WriteHeader(http.StatusOK)
 	return true
 }
+// handleSection reads or writes one section of a page, the one under the
+// heading given as an id or as written.  Writes send the page's Body and the
+// Version it was read at, and fail with a conflict if the page has changed
+// since.
+func handleSection(w http.ResponseWriter, r *http.Request, s storage) bool {
+	wiki := r.URL.Query().Get("wiki")
+	id := r.URL.Query().Get("section")
+	if wiki == "" || id == "" {
+		return false
+	}
+
+	defer lockPage(wiki)()
+	current, err := s.getPage(&wikiPage{basePage: basePage{Title: wiki}})
+	if err != nil {
+		http.Error(w, "No such page", http.StatusNotFound)
+		return true
+	}
+
+	switch r.Method {
+	case "GET":
+		section, ok := pageSection(string(current.Body), id)
+		if !ok {
+			http.Error(w, "No such section", http.StatusNotFound)
+			return true
+		}
+		current.Body = template.HTML(section)
+		current.Section = id
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(current)
+	case "POST":
+		var wp wikiPage
+		if err := json.NewDecoder(r.Body).Decode(&wp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return true
+		}
+		if wp.Version == "" {
+			http.Error(w, "The Version the section was read at is needed", http.StatusBadRequest)
+			return true
+		}
+		if current.Version != wp.Version {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(current)
+			return true
+		}
+		edited, ok := current.withSection(id, string(wp.Body))
+		if !ok {
+			http.Error(w, "No such section", http.StatusNotFound)
+			return true
+		}
+		edited.Author = requestAuthor(r)
+		if err := edited.save(s); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleDraft keeps the editor's autosaved copy of a page.  A POST of the
+// draft's Body, along with the Version and Section being edited, stores it,
+// a GET gives it back and a DELETE discards it.  Drafts of encrypted pages
+// are encrypted too.
+func handleDraft(w http.ResponseWriter, r *http.Request, s storage) bool {
+	title := r.URL.Query().Get("draft")
+	if title == "" {
+		return false
+	}
+	if !validTitle(title) {
+		http.Error(w, fmt.Sprintf("%q isn't a page name", title), http.StatusBadRequest)
+		return true
+	}
+
+	switch r.Method {
+	case "GET":
+		d, err := readDraft(title)
+		if err != nil {
+			http.Error(w, "No draft", http.StatusNotFound)
+			return true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(d)
+	case "POST":
+		var d pageDraft
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return true
+		}
+		if p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}}); err == nil && p.Encrypted {
+			d.Encrypted = true
+		}
+		d.Saved = time.Now()
+		if err := storeDraft(title, &d); err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"saved": d.SavedStr()})
+	case "DELETE":
+		if err := discardDraft(title); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		return false
+	}
+	return true
+}
+
+// handlePreview renders posted markdown for a page exactly as viewing the
+// page would, table of contents included, without saving anything
+func handlePreview(w http.ResponseWriter, r *http.Request, s storage) bool {
+	if r.Method != "POST" {
+		return false
+	}
+
+	title := r.URL.Query().Get("preview")
+	if title == "" {
+		return false
+	}
+
+	var wp wikiPage
+	if err := json.NewDecoder(r.Body).Decode(&wp); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	p := renderPage(s, &wikiPage{basePage: basePage{Title: title}, Body: wp.Body})
+
+	var preview bytes.Buffer
+	if p.ShowTOC() {
+		if err := templates.ExecuteTemplate(&preview, "toc", p.Index); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+	}
+	preview.WriteString(string(p.Body))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"html": preview.String()})
+	return true
+}
+
+// handleCapture adds a line to a page from a script or bookmarklet, at the
+// end with append=<page> or the start with prepend=<page>.  The text comes
+// from the text parameter and can go under a heading, be stamped with the
+// time, add tags and, for a new page, ask for it to be encrypted.
+func handleCapture(w http.ResponseWriter, r *http.Request, s storage) bool {
+	if r.Method != "POST" {
+		return false
+	}
+
+	title, prepend := r.URL.Query().Get("append"), false
+	if title == "" {
+		title, prepend = r.URL.Query().Get("prepend"), true
+	}
+	if title == "" {
+		return false
+	}
+	if !validTitle(title) {
+		http.Error(w, fmt.Sprintf("%q isn't a page name", title), http.StatusBadRequest)
+		return true
+	}
+
+	c := capture{
+		Text:      r.FormValue("text"),
+		Heading:   strings.TrimSpace(r.FormValue("heading")),
+		Prepend:   prepend,
+		Timestamp: isSet(r.FormValue("timestamp")),
+		Encrypt:   isSet(r.FormValue("encrypt")),
+		Author:    requestAuthor(r),
+	}
+	if strings.TrimSpace(c.Text) == "" {
+		http.Error(w, "Form param 'text' needs setting", http.StatusBadRequest)
+		return true
+	}
+	if tags := r.FormValue("tags"); tags != "" {
+		c.Tags = strings.Split(tags, ",")
+	}
+
+	if err := captureText(s, title, c, time.Now()); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"wiki": title})
+	return true
+}
+
+// isSet reads a flag given as a form value, such as a ticked checkbox
+func isSet(v string) bool {
+	switch strings.ToLower(v) {
+	case "on", "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// handleBacklinks lists the pages that link to a page
+func handleBacklinks(w http.ResponseWriter, r *http.Request, s storage) bool {
+	if r.Method != "GET" {
+		return false
+	}
+
+	title := r.URL.Query().Get("backlinks")
+	if title == "" {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.getBacklinks(title))
+	return true
+}
+
+// handleReport returns one of the wiki reports, currently just links
+func handleReport(w http.ResponseWriter, r *http.Request, s storage) bool {
+	if r.Method != "GET" {
+		return false
+	}
+
+	report := r.URL.Query().Get("report")
+	if report == "" {
+		return false
+	}
+	if report != "links" {
+		http.Error(w, "Unknown report", http.StatusNotFound)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(buildLinkReport(s))
+	return true
+}
+
+// searchLimit is how many results the API returns when no limit is given
+const searchLimit = 20
+
+// searchResponse is one page of search results from the API
+type searchResponse struct {
+	Query   string
+	Total   int
+	Offset  int
+	Limit   int
+	Results []QueryResults
+}
+
+// handleSearch runs a query, with the same syntax as the search page, and
+// returns the matching pages most relevant first.  offset and limit page
+// through the results.
+func handleSearch(w http.ResponseWriter, r *http.Request, s storage) bool {
+	if r.Method != "GET" {
+		return false
+	}
+
+	term := r.URL.Query().Get("search")
+	if term == "" {
+		return false
+	}
+
+	resp := searchResponse{Query: term, Limit: searchLimit}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err := parseInt(v)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return true
+		}
+		resp.Offset = offset
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := parseInt(v)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return true
+		}
+		resp.Limit = limit
+	}
+
+	results, total, err := s.queryPages(term, resp.Offset, resp.Limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	resp.Total = total
+	resp.Results = results
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+	return true
+}
+
 func handleGetList(w http.ResponseWriter, r *http.Request, s storage) bool {
 	if r.Method != "GET" {
 		return false
@@ -217,6 +545,22 @@ func innerAPIHandler(w http.ResponseWriter, r *http.Request, s storage) {
 		return
 	}
 
+	if ok := handleCapture(w, r, s); ok {
+		return
+	}
+
+	if ok := handlePreview(w, r, s); ok {
+		return
+	}
+
+	if ok := handleDraft(w, r, s); ok {
+		return
+	}
+
+	if ok := handleSection(w, r, s); ok {
+		return
+	}
+
 	if ok := handleGetWiki(w, r, s); ok {
 		return
 	}
@@ -227,6 +571,15 @@ func innerAPIHandler(w http.ResponseWriter, r *http.Request, s storage) {
 	if ok := handleGetList(w, r, s); ok {
 		return
 	}
+	if ok := handleSearch(w, r, s); ok {
+		return
+	}
+	if ok := handleBacklinks(w, r, s); ok {
+		return
+	}
+	if ok := handleReport(w, r, s); ok {
+		return
+	}
 
 	w.WriteHeader(http.StatusBadRequest)
 	return
@@ -99,9 +99,9 @@ func TestWikiApiPostHandler(t *testing.T) {
 		},
 		storeFileFunc: func(name string, body []byte) error {
 			t.Logf("storeFile: Got %v/n", name)
-			// Check the first part of the string as the store file func will be called for the tags file and the
-			// md file
-			if !strings.HasPrefix(name, "fred") {
+			// Check the first part of the string as the store file func will be called for the tags file, the
+			// dates file and the md file
+			if !strings.HasPrefix(strings.TrimPrefix(name, "meta/"), "fred") {
 				t.Errorf("expecting %v but got %v", "fred", name)
 			}
 			return nil
@@ -143,3 +143,58 @@ func TestWApiListHandler(t *testing.T) {
 		t.Errorf("Expecting 2 results but got : %v", len(results))
 	}
 }
+
+func TestSearchApiHandler(t *testing.T) {
+	s := stubStorage{
+		queryPagesFunc: func(q string) ([]QueryResults, error) {
+			res := []QueryResults{}
+			for _, name := range []string{"one", "two", "three"} {
+				res = append(res, QueryResults{WikiName: name, LineNum: "1", Text: q, Highlights: [][2]int{{0, 3}}})
+			}
+			return res, nil
+		},
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost/api?search=tag:food+apples&offset=1&limit=1", nil)
+	w := httptest.NewRecorder()
+	innerAPIHandler(w, req, &s)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Failed to get a 200 response, got %v", w.Code)
+	}
+	var resp searchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 3 || resp.Offset != 1 || resp.Limit != 1 || resp.Query != "tag:food apples" {
+		t.Errorf("Unexpected paging details: %+v", resp)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].WikiName != "two" || resp.Results[0].Highlights[0] != [2]int{0, 3} {
+		t.Errorf("Expected the second result but got %+v", resp.Results)
+	}
+
+	// Past the end gives an empty list rather than null
+	req = httptest.NewRequest("GET", "http://localhost/api?search=apples&offset=5", nil)
+	w = httptest.NewRecorder()
+	innerAPIHandler(w, req, &s)
+	if !strings.Contains(w.Body.String(), `"Results":[]`) || !strings.Contains(w.Body.String(), `"Limit":20`) {
+		t.Errorf("Expected no results with the default limit: %v", w.Body.String())
+	}
+
+	for _, q := range []string{"offset=-1", "limit=0", "limit=x"} {
+		req = httptest.NewRequest("GET", "http://localhost/api?search=apples&"+q, nil)
+		w = httptest.NewRecorder()
+		innerAPIHandler(w, req, &s)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for %v, got %v", q, w.Code)
+		}
+	}
+
+	s.queryPagesFunc = parseQueryResults
+	req = httptest.NewRequest("GET", "http://localhost/api?search=(oops", nil)
+	w = httptest.NewRecorder()
+	innerAPIHandler(w, req, &s)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for a bad query, got %v", w.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"strings"
+	"time"
+)
+
+// capture is a bit of text to add to a page without going through the
+// editor
+type capture struct {
+	Text      string
+	Heading   string
+	Prepend   bool
+	Timestamp bool
+	Tags      []string
+	Encrypt   bool
+	Author    string
+}
+
+// captureText adds text to the start or end of a page, or of the section
+// under a heading, creating the page or the heading if need be.  New tags
+// are added to the page's and an encrypted page stays encrypted.  Captures
+// hold the page's lock so ones arriving together all make it in.
+func captureText(s storage, title string, c capture, now time.Time) error {
+	defer lockPage(title)()
+	p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		p = &wikiPage{basePage: basePage{Title: title}, Encrypted: c.Encrypt}
+	}
+
+	text := strings.TrimRight(strings.ReplaceAll(c.Text, "\r\n", "\n"), "\n")
+	if c.Timestamp {
+		text = now.Format(TIME_FORMAT) + " " + text
+	}
+	body := strings.ReplaceAll(string(p.Body), "\r\n", "\n")
+	p.Body = template.HTML(insertText(body, c.Heading, text, c.Prepend))
+	p.Tags = mergeTags(p.Tags, c.Tags)
+	p.Author = c.Author
+	return p.save(s)
+}
+
+// insertText puts text at the start or end of a body or of a section in it.
+// A heading that isn't there yet is added to the end.
+func insertText(body, heading, text string, prepend bool) string {
+	if heading == "" {
+		body = strings.TrimRight(body, "\n")
+		switch {
+		case body == "":
+			return text + "\n"
+		case prepend:
+			return text + "\n" + body + "\n"
+		}
+		return body + "\n" + text + "\n"
+	}
+
+	start, end, ok := sectionBounds(body, heading)
+	if !ok {
+		if body = strings.TrimRight(body, "\n"); body != "" {
+			body += "\n\n"
+		}
+		return body + "## " + heading + "\n\n" + text + "\n"
+	}
+
+	lines := strings.Split(body, "\n")
+	// The heading is two lines when underlined
+	top := start + 1
+	if atxHeading.FindString(lines[start]) == "" {
+		top++
+	}
+	at := end
+	if prepend {
+		at = top
+		for at < end && strings.TrimSpace(lines[at]) == "" {
+			at++
+		}
+	} else {
+		for at > top && strings.TrimSpace(lines[at-1]) == "" {
+			at--
+		}
+	}
+	inserted := append([]string{}, lines[:at]...)
+	inserted = append(inserted, text)
+	inserted = append(inserted, lines[at:]...)
+	return strings.Join(inserted, "\n")
+}
+
+// mergeTags adds any new tags to a page's comma separated list
+func mergeTags(tags string, more []string) string {
+	var merged []string
+	for _, t := range append(strings.Split(tags, ","), more...) {
+		if t = strings.TrimSpace(t); t != "" && !contains(t, merged) {
+			merged = append(merged, t)
+		}
+	}
+	return strings.Join(merged, ",")
+}
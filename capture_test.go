@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInsertText(t *testing.T) {
+	body := "Intro\n\n## Notes\n\nFirst note\n\n## Links\nSetext\n------\n\nOld\n"
+	for _, c := range []struct {
+		heading  string
+		prepend  bool
+		expected string
+	}{
+		{"", false, body + "new\n"},
+		{"", true, "new\n" + body},
+		{"Notes", false, "Intro\n\n## Notes\n\nFirst note\nnew\n\n## Links\nSetext\n------\n\nOld\n"},
+		{"Notes", true, "Intro\n\n## Notes\n\nnew\nFirst note\n\n## Links\nSetext\n------\n\nOld\n"},
+		{"links", true, "Intro\n\n## Notes\n\nFirst note\n\n## Links\nnew\nSetext\n------\n\nOld\n"},
+		{"Setext", true, "Intro\n\n## Notes\n\nFirst note\n\n## Links\nSetext\n------\n\nnew\nOld\n"},
+		{"Later", false, body + "\n## Later\n\nnew\n"},
+	} {
+		if out := insertText(body, c.heading, "new", c.prepend); out != c.expected {
+			t.Errorf("Expected %q under %q (prepend %v) but got %q", c.expected, c.heading, c.prepend, out)
+		}
+	}
+	if out := insertText("", "", "new", false); out != "new\n" {
+		t.Errorf("Expected just the text for an empty page but got %q", out)
+	}
+}
+
+func TestCaptureAPI(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+	cached.enableSearch("")
+	if err := (&wikiPage{basePage: basePage{Title: "secret"}, Body: "hidden", Tags: "private", Encrypted: true}).save(&cached); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(query string, form url.Values) int {
+		req := httptest.NewRequest("POST", "http://localhost/api?"+query, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		innerAPIHandler(w, req, &cached)
+		return w.Code
+	}
+
+	if code := post("append=inbox", url.Values{"text": {"first capture"}, "tags": {"inbox, notes"}}); code != http.StatusOK {
+		t.Fatalf("Expected the page to be created, got %v", code)
+	}
+	if code := post("prepend=inbox", url.Values{"text": {"urgent capture"}, "heading": {"Today"}, "timestamp": {"on"}, "tags": {"notes,todo"}}); code != http.StatusOK {
+		t.Fatalf("Expected the text to be added, got %v", code)
+	}
+	if code := post("append=inbox", url.Values{"text": {" "}}); code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 without any text, got %v", code)
+	}
+	for _, q := range []string{"append=../../escaped", "prepend=folder/../../escaped"} {
+		if code := post(q, url.Values{"text": {"sneaky"}}); code != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for %v, got %v", q, code)
+		}
+	}
+	if _, err := os.Stat(getWikiFilename(wikiDir, "../../escaped")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written outside the wiki")
+	}
+	cached.rebuilds.Wait()
+
+	p, err := cached.getPage(&wikiPage{basePage: basePage{Title: "inbox"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(p.Body), "\n")
+	if len(lines) < 5 || lines[0] != "first capture" || lines[2] != "## Today" || !strings.HasSuffix(lines[4], " urgent capture") {
+		t.Fatalf("Expected the captures in the page but got %q", p.Body)
+	}
+	if _, err := time.ParseInLocation(TIME_FORMAT, strings.TrimSuffix(lines[4], " urgent capture"), time.Local); err != nil {
+		t.Errorf("Expected a timestamp on the capture but got %q", lines[4])
+	}
+	if p.Tags != "inbox,notes,todo" {
+		t.Errorf("Expected the tags merged but got %q", p.Tags)
+	}
+	if res, _, _ := cached.queryPages("urgent", 0, 0); len(res) != 1 {
+		t.Errorf("Expected the capture to be searchable but got %+v", res)
+	}
+
+	if code := post("append=secret", url.Values{"text": {"more hidden"}}); code != http.StatusOK {
+		t.Fatalf("Expected the encrypted page to take the text, got %v", code)
+	}
+	raw, _ := os.ReadFile(getWikiFilename(wikiDir, "secret"))
+	if !bytes.HasPrefix(raw, encryptionFlag) || bytes.Contains(raw, []byte("more hidden")) {
+		t.Errorf("Expected the page to stay encrypted")
+	}
+	if p, _ := cached.getPage(&wikiPage{basePage: basePage{Title: "secret"}}); string(p.Body) != "hidden\nmore hidden\n" || p.Tags != "private" {
+		t.Errorf("Expected the text added to the encrypted page but got %+v", p)
+	}
+
+	post("append=new/locked", url.Values{"text": {"locked away"}, "encrypt": {"on"}})
+	if raw, _ := os.ReadFile(getWikiFilename(wikiDir, "new/locked")); !bytes.HasPrefix(raw, encryptionFlag) {
+		t.Errorf("Expected a new page to be encrypted when asked")
+	}
+	cached.rebuilds.Wait()
+}
+
+// TestConcurrentCaptures checks captures arriving together don't lose each
+// other's text
+func TestConcurrentCaptures(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := slowStorage{&fileStorage{TagDir: tagDir}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := captureText(fs, "inbox", capture{Text: fmt.Sprintf("entry %v", i)}, time.Now()); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	p, err := fs.getPage(&wikiPage{basePage: basePage{Title: "inbox"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if !strings.Contains(string(p.Body), fmt.Sprintf("entry %v\n", i)) {
+			t.Errorf("Expected entry %v on the page but got %q", i, p.Body)
+		}
+	}
+}
@@ -13,10 +13,20 @@ import (
 
 // Config object loaded from disk at startup
 type Config struct {
-	WikiDir       string
-	Logfile       string
-	HTTPPort      int
-	EncryptionKey string
+	WikiDir         string
+	Logfile         string
+	HTTPPort        int
+	EncryptionKey   string
+	Storage         string
+	GitRemote       string
+	Cache           bool
+	Watch           string
+	PollInterval    int
+	TrashDays       int
+	Metadata        string
+	AuthorHeader    string
+	JournalPath     string
+	JournalTemplate string
 }
 
 // getenv returns an env var if it is set or the default passed in
@@ -33,8 +43,17 @@ func getenv(key, fallback string) string {
 func LoadConfig() (*Config, error) {
 	path := "config.json"
 	config := Config{
-		WikiDir:  "./wikidir",
-		HTTPPort: 8080,
+		WikiDir:         "./wikidir",
+		HTTPPort:        8080,
+		Storage:         "file",
+		Cache:           true,
+		Watch:           "notify",
+		PollInterval:    10,
+		TrashDays:       30,
+		Metadata:        "sidecar",
+		AuthorHeader:    "",
+		JournalPath:     "Journal/2006/01/2006-01-02",
+		JournalTemplate: "Templates/Journal",
 	}
 	conf, err := ioutil.ReadFile(path)
 	if err == nil {
@@ -49,6 +68,16 @@ func LoadConfig() (*Config, error) {
 	config.WikiDir = getenv("WIKIDIR", config.WikiDir)
 	config.Logfile = getenv("LOGFILE", config.Logfile)
 	config.EncryptionKey = getenv("ENCRYPTIONKEY", config.EncryptionKey)
+	config.Storage = getenv("STORAGE", config.Storage)
+	config.GitRemote = getenv("GITREMOTE", config.GitRemote)
+	config.Cache, _ = strconv.ParseBool(getenv("CACHE", strconv.FormatBool(config.Cache)))
+	config.Watch = getenv("WATCH", config.Watch)
+	config.PollInterval, _ = strconv.Atoi(getenv("POLLINTERVAL", strconv.Itoa(config.PollInterval)))
+	config.TrashDays, _ = strconv.Atoi(getenv("TRASHDAYS", strconv.Itoa(config.TrashDays)))
+	config.Metadata = getenv("METADATA", config.Metadata)
+	config.AuthorHeader = getenv("AUTHORHEADER", config.AuthorHeader)
+	config.JournalPath = getenv("JOURNALPATH", config.JournalPath)
+	config.JournalTemplate = getenv("JOURNALTEMPLATE", config.JournalTemplate)
 	if len(config.EncryptionKey) == 0 {
 		config.EncryptionKey = randstr.String(32)
 		fmt.Printf("Generated EncryptionKey '%v' be sure to add to your config", config.EncryptionKey)
@@ -59,6 +88,23 @@ func LoadConfig() (*Config, error) {
 		config.WikiDir = config.WikiDir + "/"
 	}
 
+	if config.Storage != "file" && config.Storage != "git" {
+		return nil, fmt.Errorf("Storage should be either file or git not %v", config.Storage)
+	}
+
+	if config.Watch != "notify" && config.Watch != "poll" && config.Watch != "off" {
+		return nil, fmt.Errorf("Watch should be one of notify, poll or off not %v", config.Watch)
+	}
+	if config.Metadata != "sidecar" && config.Metadata != "frontmatter" {
+		return nil, fmt.Errorf("Metadata should be either sidecar or frontmatter not %v", config.Metadata)
+	}
+	if !validJournalPath(config.JournalPath) {
+		return nil, fmt.Errorf("JournalPath should be a date layout with the year, month and day such as Journal/2006/01/2006-01-02 not %v", config.JournalPath)
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 10
+	}
+
 	if len(config.EncryptionKey) != 32 {
 		return nil, fmt.Errorf("Need to set EncryptionKey to be 32 char string not %v",
 			len(config.EncryptionKey))
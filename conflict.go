@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// conflictPage is shown when a save was based on an out of date copy of a page
+type conflictPage struct {
+	basePage
+	Version   string
+	Mine      string
+	Theirs    string
+	Tags      string
+	Published bool
+	Encrypted bool
+	Lines     []diffLine
+}
+
+// pageVersion identifies the stored form of a page so that a save can tell
+// whether the page has changed since the editor loaded it
+func pageVersion(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// pageLocks holds a lock per page so that checking a page's version and
+// saving over it happen together, without another save of the same page
+// getting in between
+var pageLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: map[string]*sync.Mutex{}}
+
+// lockPage takes the lock for a page, returning the func that releases it
+func lockPage(title string) func() {
+	pageLocks.Lock()
+	l, ok := pageLocks.locks[title]
+	if !ok {
+		l = &sync.Mutex{}
+		pageLocks.locks[title] = l
+	}
+	pageLocks.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// checkVersion compares the version an edit was based on with what is stored
+// now.  An empty version means the edit started from a page that did not
+// exist.  It returns the current page when they differ.  Callers hold the
+// page's lock until they have saved.
+func checkVersion(s storage, title, version string) (*wikiPage, bool) {
+	current, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+	if err != nil {
+		// Nothing stored so only a conflict if the page was deleted under us
+		if version == "" {
+			return nil, true
+		}
+		return &wikiPage{basePage: basePage{Title: title}}, false
+	}
+	if current.Version == version {
+		return current, true
+	}
+	return current, false
+}
+
+// conflictHandler renders both versions along with a merge view of the
+// changes and a form to save a resolved copy against the current version
+func conflictHandler(w http.ResponseWriter, mine, current *wikiPage) {
+	cp := conflictPage{
+		basePage:  basePage{Title: mine.Title},
+		Version:   current.Version,
+		Mine:      string(mine.Body),
+		Theirs:    string(current.Body),
+		Tags:      mine.Tags,
+		Published: mine.Published,
+		Encrypted: mine.Encrypted,
+		Lines:     diffLines(string(current.Body), string(mine.Body)),
+	}
+	w.WriteHeader(http.StatusConflict)
+	if err := templates.ExecuteTemplate(w, "conflict.html", cp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// MergeBody prefills the editor on the conflict page.  Unchanged lines are
+// kept and each changed block holds both sides between git style markers so
+// the user can pick what to keep.
+func (cp conflictPage) MergeBody() string {
+	var merged, current, mine []string
+	flush := func() {
+		if len(current) == 0 && len(mine) == 0 {
+			return
+		}
+		merged = append(merged, "<<<<<<< current")
+		merged = append(merged, current...)
+		merged = append(merged, "=======")
+		merged = append(merged, mine...)
+		merged = append(merged, ">>>>>>> mine")
+		current, mine = nil, nil
+	}
+	for _, l := range cp.Lines {
+		switch l.Op {
+		case "del":
+			current = append(current, l.Text)
+		case "add":
+			mine = append(mine, l.Text)
+		default:
+			flush()
+			merged = append(merged, l.Text)
+		}
+	}
+	flush()
+	return strings.Join(merged, "\n")
+}
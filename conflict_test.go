@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func conflictStub(saved *bool) stubStorage {
+	return stubStorage{
+		getPageFunc: func(pg *wikiPage) (*wikiPage, error) {
+			pg.Body = "their body"
+			pg.Version = "current"
+			return pg, nil
+		},
+		storeFileFunc: func(name string, content []byte) error {
+			*saved = true
+			return nil
+		},
+	}
+}
+
+func TestSaveHandlerConflict(t *testing.T) {
+	saved := false
+	s := conflictStub(&saved)
+
+	form := url.Values{}
+	form.Add("body", "my body")
+	form.Add("version", "stale")
+	req := httptest.NewRequest("POST", "http://localhost/wiki/save/test", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	saveHandler(w, req, "test", &s)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected a 409 response, got %v", w.Code)
+	}
+	if saved {
+		t.Error("Page was saved despite the conflict")
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "their body") || !strings.Contains(body, "my body") {
+		t.Errorf("Expected both versions on the conflict page: %v", body)
+	}
+	if !strings.Contains(body, `value="current"`) {
+		t.Errorf("Expected the merge form to be based on the current version: %v", body)
+	}
+}
+
+func TestSaveHandlerCurrentVersion(t *testing.T) {
+	saved := false
+	s := conflictStub(&saved)
+
+	form := url.Values{}
+	form.Add("body", "my body")
+	form.Add("version", "current")
+	req := httptest.NewRequest("POST", "http://localhost/wiki/save/test", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	saveHandler(w, req, "test", &s)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected a 302 response, got %v", w.Code)
+	}
+	if !saved {
+		t.Error("Page was not saved")
+	}
+}
+
+func TestSaveHandlerNewPageCreatedMeanwhile(t *testing.T) {
+	saved := false
+	s := conflictStub(&saved)
+
+	form := url.Values{}
+	form.Add("body", "my body")
+	form.Add("version", "")
+	req := httptest.NewRequest("POST", "http://localhost/wiki/save/test", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	saveHandler(w, req, "test", &s)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected a 409 response, got %v", w.Code)
+	}
+}
+
+func TestCheckVersionMissingPage(t *testing.T) {
+	s := stubStorage{
+		getPageFunc: func(pg *wikiPage) (*wikiPage, error) {
+			return pg, errors.New("not found")
+		},
+	}
+	if _, same := checkVersion(&s, "test", ""); !same {
+		t.Error("A new page should not conflict with nothing")
+	}
+	if _, same := checkVersion(&s, "test", "abc"); same {
+		t.Error("A page deleted since it was loaded should conflict")
+	}
+}
+
+func TestWikiApiPostConflict(t *testing.T) {
+	saved := false
+	s := conflictStub(&saved)
+
+	data, _ := json.Marshal(wikiPage{basePage: basePage{Title: "fred"}, Body: "my body", Version: "stale"})
+	req := httptest.NewRequest("POST", "http://localhost/api?wiki=fred", strings.NewReader(string(data)))
+	w := httptest.NewRecorder()
+
+	innerAPIHandler(w, req, &s)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected a 409 response, got %v", w.Code)
+	}
+	var current wikiPage
+	if err := json.Unmarshal(w.Body.Bytes(), &current); err != nil {
+		t.Fatal(err)
+	}
+	if current.Version != "current" || string(current.Body) != "their body" {
+		t.Errorf("Expected the current page back but got %+v", current)
+	}
+	if saved {
+		t.Error("Page was saved despite the conflict")
+	}
+}
+
+func TestMergeBody(t *testing.T) {
+	cp := conflictPage{Lines: diffLines("a\nb\nc", "a\nB\nc")}
+	expected := "a\n<<<<<<< current\nb\n=======\nB\n>>>>>>> mine\nc"
+	if cp.MergeBody() != expected {
+		t.Errorf("Expected %q but got %q", expected, cp.MergeBody())
+	}
+}
+
+// slowStorage takes a while to read pages, leaving room for saves to
+// overlap
+type slowStorage struct {
+	*fileStorage
+}
+
+func (ss slowStorage) getPage(p *wikiPage) (*wikiPage, error) {
+	time.Sleep(5 * time.Millisecond)
+	return ss.fileStorage.getPage(p)
+}
+
+// TestConcurrentSaves has several editors save at once from the same version,
+// only one of them can win
+func TestConcurrentSaves(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := slowStorage{&fileStorage{TagDir: tagDir}}
+	if err := (&wikiPage{basePage: basePage{Title: "page"}, Body: "start"}).save(&fs); err != nil {
+		t.Fatal(err)
+	}
+	start, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "page"}})
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			form := url.Values{}
+			form.Add("body", fmt.Sprintf("edit %v", i))
+			form.Add("version", start.Version)
+			req := httptest.NewRequest("POST", "http://localhost/wiki/save/page", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			saveHandler(w, req, "page", fs)
+			codes <- w.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	saved := 0
+	for code := range codes {
+		if code == http.StatusFound {
+			saved++
+		}
+	}
+	if saved != 1 {
+		t.Errorf("Expected exactly one save to win but %v did", saved)
+	}
+}
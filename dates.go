@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// File times can't be trusted for when a page was created or changed,
+// syncing with e.g. Dropbox rewrites them, so saves record a page's dates in
+// a file in the meta folder.  The page's size is kept as well so a page
+// changed outside the wiki can be spotted and its file time used instead.
+// Who made the first and latest saves goes alongside, when it is known.
+type pageDates struct {
+	Created    time.Time
+	Modified   time.Time
+	Size       int64
+	CreatedBy  string `json:",omitempty"`
+	ModifiedBy string `json:",omitempty"`
+}
+
+// authorHeader is the request header the proxy in front of the wiki names
+// the signed in user with, "" if there isn't one.  Anyone can set a header
+// so it is only trusted when configured.
+var authorHeader = ""
+
+// requestAuthor is who is making a request, "" when that isn't known
+func requestAuthor(r *http.Request) string {
+	if authorHeader == "" {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(authorHeader))
+}
+
+func getWikiMetaFilename(name string) string {
+	return wikiDir + "meta/" + name
+}
+
+// readPageDates gives the recorded dates for a page, ok is false for pages
+// that have never been saved since dates were recorded
+func readPageDates(title string) (pageDates, bool) {
+	var dates pageDates
+	data, err := os.ReadFile(getWikiMetaFilename(title))
+	if err != nil {
+		return dates, false
+	}
+	if err := json.Unmarshal(data, &dates); err != nil {
+		return dates, false
+	}
+	return dates, true
+}
+
+// pageTimes works out when, and by whom, a page was created and last
+// modified from its recorded dates, falling back on the file's time.
+// Created is zero when nothing says when that was.
+func pageTimes(title string, info os.FileInfo) pageDates {
+	dates, ok := readPageDates(title)
+	if !ok {
+		return pageDates{Modified: info.ModTime()}
+	}
+	if dates.Size != info.Size() {
+		// Changed by something other than the wiki
+		dates.Modified = info.ModTime()
+		dates.ModifiedBy = ""
+	}
+	return dates
+}
+
+// parseDate reads a date written as RFC 3339 or just the day
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// formatDate is how dates are shown on pages and in the menu
+func formatDate(s string) string {
+	t, err := parseDate(s)
+	if err != nil {
+		return s
+	}
+	return t.Local().Format(TIME_FORMAT)
+}
+
+func (p wikiPage) CreatedStr() string {
+	return formatDate(p.Created)
+}
+
+func (p wikiPage) ModifiedStr() string {
+	return formatDate(p.Modified)
+}
+
+// stampDates sets the page's dates for a save happening now by the page's
+// Author.  The created date is whatever the page says, what was recorded
+// before, or failing that the time of the file being replaced.  Who created
+// a page is only known when it is created by a save.
+func (p *wikiPage) stampDates(now time.Time) pageDates {
+	dates, ok := readPageDates(p.Title)
+	if !ok {
+		dates.Created = now
+		dates.CreatedBy = p.Author
+		if info, err := os.Stat(getWikiFilename(wikiDir, p.Title)); err == nil {
+			dates.Created = info.ModTime()
+			dates.CreatedBy = ""
+		}
+	}
+	if t, err := parseDate(p.Created); err == nil {
+		dates.Created = t
+	}
+	dates.Modified = now
+	dates.ModifiedBy = p.Author
+	p.Created = dates.Created.Format(time.RFC3339)
+	p.Modified = dates.Modified.Format(time.RFC3339)
+	p.CreatedBy = dates.CreatedBy
+	p.ModifiedBy = dates.ModifiedBy
+	return dates
+}
+
+// storeDates records the dates for a page whose file is size bytes
+func storeDates(s storage, title string, dates pageDates, size int) error {
+	dates.Size = int64(size)
+	data, err := json.Marshal(dates)
+	if err != nil {
+		return err
+	}
+	return s.storeFile(getWikiMetaFilename(title), data)
+}
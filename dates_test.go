@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageDates(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	// A page from before dates were recorded
+	os.WriteFile(getWikiFilename(wikiDir, "old"), []byte("old page"), 0644)
+	longAgo := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(getWikiFilename(wikiDir, "old"), longAgo, longAgo)
+
+	p, err := fs.getPage(&wikiPage{basePage: basePage{Title: "old"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Created != "" || p.Modified != longAgo.Local().Format(time.RFC3339) {
+		t.Errorf("Expected no created date and the file's time but got %q and %q", p.Created, p.Modified)
+	}
+
+	p.Body = "edited"
+	if err := p.save(&fs); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "old"}})
+	if created, _ := parseDate(saved.Created); !created.Equal(longAgo) {
+		t.Errorf("Expected the created date to come from the old file but got %v", saved.Created)
+	}
+	modified, _ := parseDate(saved.Modified)
+	if time.Since(modified) > time.Minute {
+		t.Errorf("Expected modified to be now but got %v", saved.Modified)
+	}
+
+	// Sync tools touching the file don't change the dates
+	os.Chtimes(getWikiFilename(wikiDir, "old"), time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	touched, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "old"}})
+	if touched.Modified != saved.Modified || touched.Created != saved.Created {
+		t.Errorf("Expected the recorded dates but got %q and %q", touched.Created, touched.Modified)
+	}
+	nav := fs.IndexWikiFiles("", wikiDir)
+	if len(nav) != 1 || !nav[0].Mod.Truncate(time.Second).Equal(modified) || nav[0].ModStr != saved.ModifiedStr() {
+		t.Errorf("Expected the nav to use the recorded date but got %+v", nav)
+	}
+
+	// But a page edited outside the wiki shows when that happened
+	edited := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.WriteFile(getWikiFilename(wikiDir, "old"), []byte("edited elsewhere"), 0644)
+	os.Chtimes(getWikiFilename(wikiDir, "old"), edited, edited)
+	outside, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "old"}})
+	if outside.Modified != edited.Local().Format(time.RFC3339) || outside.Created != saved.Created {
+		t.Errorf("Expected the file's time for an outside edit but got %q", outside.Modified)
+	}
+
+	w := httptest.NewRecorder()
+	innerAPIHandler(w, httptest.NewRequest("GET", "http://localhost/api?wiki=old", nil), &fs)
+	var fromAPI struct{ Created, Modified string }
+	if err := json.Unmarshal(w.Body.Bytes(), &fromAPI); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{fromAPI.Created, fromAPI.Modified} {
+		if _, err := time.Parse(time.RFC3339, d); err != nil {
+			t.Errorf("Expected RFC 3339 dates from the API but got %q", d)
+		}
+	}
+}
+
+func TestPageAuthors(t *testing.T) {
+	defer useTempWiki(t)()
+	defer func(original string) { authorHeader = original }(authorHeader)
+	fs := fileStorage{TagDir: tagDir}
+
+	// Nobody is recorded unless the header has been configured
+	form := url.Values{}
+	form.Add("body", "anonymous")
+	req := httptest.NewRequest("POST", "http://localhost/wiki/save/anon", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-User", "mallory")
+	saveHandler(httptest.NewRecorder(), req, "anon", &fs)
+	if dates, _ := readPageDates("anon"); dates.CreatedBy != "" || dates.ModifiedBy != "" {
+		t.Errorf("Expected no authors without AuthorHeader but got %+v", dates)
+	}
+
+	authorHeader = "X-Forwarded-User"
+
+	save := func(user string) {
+		form := url.Values{}
+		form.Add("body", "by "+user)
+		req := httptest.NewRequest("POST", "http://localhost/wiki/save/page", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-User", user)
+		saveHandler(httptest.NewRecorder(), req, "page", &fs)
+	}
+
+	save("alice")
+	save("bob")
+	p, err := fs.getPage(&wikiPage{basePage: basePage{Title: "page"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CreatedBy != "alice" || p.ModifiedBy != "bob" {
+		t.Errorf("Expected alice to have created the page and bob to have changed it but got %q and %q", p.CreatedBy, p.ModifiedBy)
+	}
+
+	w := httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/page", nil), &wikiPage{basePage: basePage{Title: "page"}}, &fs)
+	if out := w.Body.String(); !strings.Contains(out, "by alice") || !strings.Contains(out, "by bob") {
+		t.Errorf("Expected the view to say who created and changed the page but got %v", out)
+	}
+
+	// Nobody can say who changed a page outside the wiki
+	os.WriteFile(getWikiFilename(wikiDir, "page"), []byte("edited elsewhere"), 0644)
+	outside, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "page"}})
+	if outside.CreatedBy != "alice" || outside.ModifiedBy != "" {
+		t.Errorf("Expected only the creator for an outside edit but got %q and %q", outside.CreatedBy, outside.ModifiedBy)
+	}
+
+	// Nor who created a page that was there before the wiki saved it
+	os.WriteFile(getWikiFilename(wikiDir, "older"), []byte("old page"), 0644)
+	older := wikiPage{basePage: basePage{Title: "older"}, Body: "edited", Author: "carol"}
+	if err := older.save(&fs); err != nil {
+		t.Fatal(err)
+	}
+	if dates, _ := readPageDates("older"); dates.CreatedBy != "" || dates.ModifiedBy != "carol" {
+		t.Errorf("Expected only who modified the page but got %+v", dates)
+	}
+}
+
+func TestCachedNavFollowsDates(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+
+	for _, title := range []string{"first", "second"} {
+		if err := (&wikiPage{basePage: basePage{Title: title}, Body: "body"}).save(&cached); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Make first the most recently modified by its dates alone
+	dates, _ := readPageDates("first")
+	dates.Modified = time.Now().Add(time.Hour)
+	info, _ := os.Stat(getWikiFilename(wikiDir, "first"))
+	if err := storeDates(&cached, "first", dates, int(info.Size())); err != nil {
+		t.Fatal(err)
+	}
+
+	nav := cached.IndexWikiFiles("", wikiDir)
+	if len(nav) != 2 || nav[0].Name != "first" {
+		t.Errorf("Expected first at the top of the menu but got %+v", nav)
+	}
+}
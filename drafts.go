@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// pageDraft is what was in the editor for a page when it was last
+// autosaved.  Drafts are scratch copies so they are written straight to the
+// drafts folder rather than through the storage, keeping them out of the
+// page's history and git.
+type pageDraft struct {
+	Body      string
+	Version   string
+	Section   string
+	Encrypted bool
+	Saved     time.Time
+}
+
+func getWikiDraftFilename(name string) string {
+	return wikiDir + "drafts/" + name
+}
+
+// readDraft gives the draft for a page, decrypting it if need be
+func readDraft(title string) (*pageDraft, error) {
+	data, err := os.ReadFile(getWikiDraftFilename(title))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, encryptionFlag) {
+		data, err = decrypt(bytes.TrimPrefix(data, encryptionFlag), ekey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var d pageDraft
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// storeDraft keeps a draft for a page, encrypted when the page will be
+func storeDraft(title string, d *pageDraft) error {
+	if !validTitle(title) {
+		return fmt.Errorf("%q isn't a page name", title)
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if d.Encrypted {
+		data, err = encrypt(data, ekey)
+		if err != nil {
+			return err
+		}
+		data = append(encryptionFlag, data...)
+	}
+	filename := getWikiDraftFilename(title)
+	if err := createDir(filename); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// discardDraft removes a page's draft, if it has one
+func discardDraft(title string) error {
+	if err := os.Remove(getWikiDraftFilename(title)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// moveDraft takes a page's draft with it when the page moves
+func moveDraft(from, to string) error {
+	// Don't leave empty folders behind for pages without drafts
+	src := getWikiDraftFilename(from)
+	if _, err := os.Stat(src); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	dest := getWikiDraftFilename(to)
+	if err := createDir(dest); err != nil {
+		return err
+	}
+	return os.Rename(src, dest)
+}
+
+// newerDraft gives the draft worth offering to the editor of a page, one for
+// the same section that has been saved since the page was and differs from
+// it.  Otherwise it is nil.
+func newerDraft(p *wikiPage) *pageDraft {
+	d, err := readDraft(p.Title)
+	if err != nil || d.Section != p.Section || d.Body == string(p.Body) {
+		return nil
+	}
+	if modified, err := parseDate(p.Modified); err == nil && !d.Saved.After(modified) {
+		return nil
+	}
+	return d
+}
+
+func (d pageDraft) SavedStr() string {
+	return d.Saved.Local().Format(TIME_FORMAT)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postDraft(t *testing.T, s storage, title string, d pageDraft) {
+	data, _ := json.Marshal(d)
+	w := httptest.NewRecorder()
+	innerAPIHandler(w, httptest.NewRequest("POST", "http://localhost/api?draft="+url.QueryEscape(title), bytes.NewReader(data)), s)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the draft to be stored, got %v: %v", w.Code, w.Body.String())
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	if err := (&wikiPage{basePage: basePage{Title: "page"}, Body: "saved body"}).save(&fs); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(getWikiFilename(wikiDir, "page"))
+
+	postDraft(t, &fs, "page", pageDraft{Body: "half written"})
+	if after, _ := os.ReadFile(getWikiFilename(wikiDir, "page")); !bytes.Equal(before, after) {
+		t.Errorf("Expected the page itself to be left alone")
+	}
+
+	w := httptest.NewRecorder()
+	innerAPIHandler(w, httptest.NewRequest("GET", "http://localhost/api?draft=page", nil), &fs)
+	var got pageDraft
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Body != "half written" {
+		t.Errorf("Expected the draft back from the API but got %v: %v", w.Code, w.Body.String())
+	}
+
+	edit := func(target string) string {
+		w := httptest.NewRecorder()
+		editHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/edit/"+target, nil), &wikiPage{basePage: basePage{Title: strings.Split(target, "?")[0]}}, &fs)
+		return w.Body.String()
+	}
+	if out := edit("page"); !strings.Contains(out, `id="draft-banner"`) || !strings.Contains(out, "half written") {
+		t.Errorf("Expected the editor to offer the draft but got %v", out)
+	}
+	if out := edit("page?section=nowhere"); strings.Contains(out, `id="draft-banner"`) {
+		t.Errorf("Expected no offer of a whole page draft when editing a section")
+	}
+
+	// Saving the page is the end of the draft
+	form := url.Values{}
+	form.Add("body", "new body")
+	req := httptest.NewRequest("POST", "http://localhost/wiki/save/page", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	saveHandler(httptest.NewRecorder(), req, "page", &fs)
+	if _, err := readDraft("page"); !os.IsNotExist(err) {
+		t.Errorf("Expected the draft to be gone after saving but got %v", err)
+	}
+	if out := edit("page"); strings.Contains(out, `id="draft-banner"`) {
+		t.Errorf("Expected no draft to offer after saving")
+	}
+
+	// A draft older than the page isn't offered
+	postDraft(t, &fs, "page", pageDraft{Body: "old draft"})
+	(&wikiPage{basePage: basePage{Title: "page"}, Body: "saved elsewhere"}).save(&fs)
+	d, _ := readDraft("page")
+	d.Saved = time.Now().Add(-time.Hour)
+	storeDraft("page", d)
+	if out := edit("page"); strings.Contains(out, `id="draft-banner"`) {
+		t.Errorf("Expected an old draft not to be offered")
+	}
+
+	w = httptest.NewRecorder()
+	innerAPIHandler(w, httptest.NewRequest("DELETE", "http://localhost/api?draft=page", nil), &fs)
+	if _, err := readDraft("page"); w.Code != http.StatusOK || !os.IsNotExist(err) {
+		t.Errorf("Expected the draft to be discarded, got %v and %v", w.Code, err)
+	}
+
+	// Drafts follow the page when it moves
+	postDraft(t, &fs, "page", pageDraft{Body: "moving draft"})
+	if _, err := movePage(&fs, "page", "folder/moved", false); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := readDraft("folder/moved"); err != nil || d.Body != "moving draft" {
+		t.Errorf("Expected the draft to move with the page but got %v, %v", d, err)
+	}
+}
+
+func TestEncryptedDrafts(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	if err := (&wikiPage{basePage: basePage{Title: "secret"}, Body: "hidden", Encrypted: true}).save(&fs); err != nil {
+		t.Fatal(err)
+	}
+
+	postDraft(t, &fs, "secret", pageDraft{Body: "secret draft words"})
+	postDraft(t, &fs, "new", pageDraft{Body: "new secret words", Encrypted: true})
+	for _, title := range []string{"secret", "new"} {
+		raw, err := os.ReadFile(getWikiDraftFilename(title))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(raw, encryptionFlag) || bytes.Contains(raw, []byte("words")) {
+			t.Errorf("Expected the draft of %v to be encrypted but got %q", title, raw)
+		}
+		if d, err := readDraft(title); err != nil || !strings.HasSuffix(d.Body, "words") {
+			t.Errorf("Expected the draft of %v to decrypt but got %v, %v", title, d, err)
+		}
+	}
+}
+
+func TestDraftTitles(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	for _, title := range []string{"../../escaped", "folder/../../escaped", "/abs"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost/api?draft="+url.QueryEscape(title), strings.NewReader(`{"Body":"x"}`))
+		innerAPIHandler(w, req, &fs)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected a draft for %q to be refused but got %v", title, w.Code)
+		}
+	}
+	if _, err := os.Stat(wikiDir + "../escaped"); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written outside the drafts folder")
+	}
+
+	// Moving a page without a draft leaves no folders behind
+	(&wikiPage{basePage: basePage{Title: "page"}, Body: "body"}).save(&fs)
+	if _, err := movePage(&fs, "page", "folder/moved", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(wikiDir + "drafts"); !os.IsNotExist(err) {
+		t.Errorf("Expected no drafts folder but got %v", err)
+	}
+}
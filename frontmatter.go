@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Page metadata can be kept as YAML front matter at the top of the page's
+// file rather than in the tags and pub sidecar files.  Pages are read the
+// same way whichever is in use, with the front matter winning where both
+// say something, and saves write whichever frontMatterMode says.
+var frontMatterMode bool
+
+// migrating is set while migrateMetadata moves metadata out of the pages,
+// so dates written in them are moved too rather than kept
+var migrating bool
+
+// frontMatter is what can go in a page's front matter.  Tags and Published
+// are nil when the page doesn't mention them so the sidecars still count.
+// Aliases are a record of the page's aliases when it was last saved, the
+// aliases folder is what is used to look them up.
+type frontMatter struct {
+	Title     string                 `yaml:"title,omitempty"`
+	Tags      []string               `yaml:"tags,omitempty,flow"`
+	Created   string                 `yaml:"created,omitempty"`
+	Aliases   []string               `yaml:"aliases,omitempty,flow"`
+	Published *bool                  `yaml:"published,omitempty"`
+	Meta      map[string]interface{} `yaml:",inline"`
+}
+
+var frontMatterStart = []byte("---\n")
+
+// splitFrontMatter separates any front matter from the rest of a page.  A
+// page that happens to start with a --- rule but has nothing that parses as
+// front matter after it is left as it is.
+func splitFrontMatter(body []byte) (*frontMatter, []byte) {
+	body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(body, frontMatterStart) {
+		return nil, body
+	}
+	rest := body[len(frontMatterStart):]
+	for i := 0; i < len(rest); {
+		line := rest[i:]
+		end := bytes.IndexByte(line, '\n')
+		if end < 0 {
+			end = len(line)
+		} else {
+			line = line[:end+1]
+		}
+		if trimmed := string(bytes.TrimRight(line, "\n")); trimmed == "---" || trimmed == "..." {
+			var fm frontMatter
+			if err := yaml.Unmarshal(rest[:i], &fm); err != nil {
+				return nil, body
+			}
+			return &fm, rest[i+len(line):]
+		}
+		i += len(line)
+	}
+	return nil, body
+}
+
+// joinFrontMatter puts front matter, if there is anything in it, back on
+// top of a page body
+func joinFrontMatter(fm *frontMatter, body []byte) ([]byte, error) {
+	if fm == nil || fm.empty() {
+		return body, nil
+	}
+	head, err := yaml.Marshal(fm)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, frontMatterStart...)
+	out = append(out, head...)
+	out = append(out, frontMatterStart...)
+	return append(out, body...), nil
+}
+
+func (fm *frontMatter) empty() bool {
+	return fm.Title == "" && fm.Tags == nil && fm.Created == "" && fm.Aliases == nil && fm.Published == nil && len(fm.Meta) == 0
+}
+
+// apply copies what the front matter says onto a page
+func (fm *frontMatter) apply(p *wikiPage) {
+	if fm.Tags != nil {
+		p.Tags = strings.Join(fm.Tags, ",")
+		p.TagArray = fm.Tags
+	}
+	if fm.Published != nil {
+		p.Published = *fm.Published
+	}
+	if fm.Created != "" {
+		p.Created = fm.Created
+		if t, err := parseDate(fm.Created); err == nil {
+			p.Created = t.Format(time.RFC3339)
+		}
+	}
+	if fm.Aliases != nil {
+		p.Aliases = fm.Aliases
+	}
+	p.Meta = fm.Meta
+}
+
+// pageFrontMatter builds the front matter saved with a page.  Tags, the
+// published flag, created date and aliases only go in when the page's
+// metadata is kept in the file, which it never is for encrypted pages as
+// nothing could index them without the key.  A created date someone wrote
+// in the file themselves is kept either way.
+func (p *wikiPage) pageFrontMatter(s storage) *frontMatter {
+	fm := &frontMatter{Meta: p.Meta}
+	if p.Encrypted {
+		return fm
+	}
+	if !frontMatterMode {
+		if !migrating {
+			fm.Created = p.writtenCreated()
+		}
+		return fm
+	}
+	fm.Title = p.Title
+	fm.Created = p.Created
+	for _, t := range GetTagsFromString(p.Tags) {
+		if t = strings.TrimSpace(t); t != "" {
+			fm.Tags = append(fm.Tags, t)
+		}
+	}
+	if p.Published {
+		fm.Published = &p.Published
+	}
+	if aliases := s.getAliases(p.Title); len(aliases) > 0 {
+		fm.Aliases = aliases
+	}
+	return fm
+}
+
+// writtenCreated is the created date in the page file's front matter, as
+// it was written unless the page now says otherwise
+func (p *wikiPage) writtenCreated() string {
+	old := readFrontMatter(getWikiFilename(wikiDir, p.Title))
+	if old == nil || old.Created == "" {
+		return ""
+	}
+	was, err := parseDate(old.Created)
+	if now, err2 := parseDate(p.Created); err2 == nil && (err != nil || !was.Equal(now)) {
+		return p.Created
+	}
+	return old.Created
+}
+
+// readFrontMatter gives the front matter from a page file, if it has any
+func readFrontMatter(filename string) *frontMatter {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	fm, _ := splitFrontMatter(body)
+	return fm
+}
+
+// frontMatters reads the front matter of every page under root that has
+// some, keyed by page title
+func frontMatters(root string) map[string]*frontMatter {
+	fms := map[string]*frontMatter{}
+	if root == "" {
+		return fms
+	}
+	for title := range listPages(root) {
+		if fm := readFrontMatter(getWikiFilename(root, title)); fm != nil {
+			fms[title] = fm
+		}
+	}
+	return fms
+}
+
+// readPageTags gives a page's tags from its front matter, or from its tags
+// file if the front matter doesn't have any
+func readPageTags(wd, td, title string) []string {
+	if fm := readFrontMatter(getWikiFilename(wd, title)); fm != nil && fm.Tags != nil {
+		return fm.Tags
+	}
+	contents, err := os.ReadFile(td + title)
+	if err != nil {
+		return nil
+	}
+	return GetTagsFromString(string(contents))
+}
+
+// publicPages combines the pub markers with what front matter says
+func publicPages(marked []string, fms map[string]*frontMatter) []string {
+	public := map[string]bool{}
+	for _, p := range marked {
+		public[p] = true
+	}
+	for title, fm := range fms {
+		if fm.Published != nil {
+			public[title] = *fm.Published
+		}
+	}
+	pages := []string{}
+	for p, ok := range public {
+		if ok {
+			pages = append(pages, p)
+		}
+	}
+	sort.Strings(pages)
+	return pages
+}
+
+// migrateMetadata re-saves every page whose metadata isn't already where
+// to, either "frontmatter" or "sidecar", says it should be
+func migrateMetadata(s storage, to string) (int, error) {
+	if to != "frontmatter" && to != "sidecar" {
+		return 0, fmt.Errorf("can only migrate to frontmatter or sidecar not %v", to)
+	}
+	original := frontMatterMode
+	frontMatterMode = to == "frontmatter"
+	migrating = true
+	defer func() { frontMatterMode, migrating = original, false }()
+
+	titles := []string{}
+	for title := range listPages(wikiDir) {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	migrated := 0
+	for _, title := range titles {
+		fm := readFrontMatter(getWikiFilename(wikiDir, title))
+		_, tagsErr := os.Stat(getWikiTagsFilename(title))
+		_, pubErr := os.Stat(getWikiPubFilename(title))
+		hasSidecars := tagsErr == nil || pubErr == nil
+		if frontMatterMode && fm != nil && fm.Title != "" && !hasSidecars {
+			continue
+		}
+		if !frontMatterMode && (fm == nil || (fm.Title == "" && fm.Created == "" && fm.Tags == nil && fm.Published == nil && fm.Aliases == nil)) {
+			continue
+		}
+
+		p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+		if err != nil {
+			return migrated, err
+		}
+		if p.Encrypted {
+			// Their metadata stays in the sidecars either way
+			continue
+		}
+		// Aliases written into the front matter by hand need registering
+		// before they are dropped from it
+		if len(p.Aliases) > 0 {
+			aliases := s.getAliases(title)
+			for _, a := range p.Aliases {
+				if contains(a, aliases) {
+					continue
+				}
+				if _, err := parseAliases(s, title, a); err != nil {
+					log.Printf("[migrate] %v: %v", title, err)
+					continue
+				}
+				aliases = append(aliases, a)
+			}
+			if err := setAliases(s, title, aliases); err != nil {
+				return migrated, err
+			}
+		}
+		if err := p.save(s); err != nil {
+			return migrated, err
+		}
+		log.Printf("[migrate] %v", title)
+		migrated++
+	}
+	return migrated, nil
+}
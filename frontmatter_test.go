@@ -0,0 +1,252 @@
+package main
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	fm, body := splitFrontMatter([]byte("---\r\ntags: [a, b]\r\npublished: true\r\nstatus: draft\r\n---\r\n# Body\r\n"))
+	if fm == nil {
+		t.Fatal("Expected front matter")
+	}
+	if !reflect.DeepEqual(fm.Tags, []string{"a", "b"}) || fm.Published == nil || !*fm.Published || fm.Meta["status"] != "draft" {
+		t.Errorf("Front matter not as expected: %+v", fm)
+	}
+	if string(body) != "# Body\n" {
+		t.Errorf("Expected the body without front matter but got %q", body)
+	}
+
+	for _, notFront := range []string{
+		"no front matter",
+		"---\nJust a rule at the top\n\nand some text",
+		"---\nnot: [closed\n---\nbody",
+		"---\ntags: [a]\nnever closed",
+	} {
+		if fm, body := splitFrontMatter([]byte(notFront)); fm != nil || string(body) != notFront {
+			t.Errorf("Expected %q to be left alone but got %+v and %q", notFront, fm, body)
+		}
+	}
+
+	out, err := joinFrontMatter(&frontMatter{}, []byte("body"))
+	if err != nil || string(out) != "body" {
+		t.Errorf("Expected empty front matter to be left off but got %q", out)
+	}
+}
+
+func TestFrontMatterOverridesSidecars(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	os.WriteFile(getWikiFilename(wikiDir, "page"), []byte("---\ntags: [front]\npublished: false\ncreated: 2024-01-02\nowner: me\n---\nThe body"), 0644)
+	os.WriteFile(getWikiTagsFilename("page"), []byte("side"), 0644)
+	os.WriteFile(getWikiPubFilename("page"), nil, 0644)
+	os.WriteFile(getWikiFilename(wikiDir, "old"), []byte("No front matter"), 0644)
+	os.WriteFile(getWikiTagsFilename("old"), []byte("side"), 0644)
+	os.WriteFile(getWikiPubFilename("old"), nil, 0644)
+
+	p, err := fs.getPage(&wikiPage{basePage: basePage{Title: "page"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Body != "The body" || p.Tags != "front" || p.Published || p.Created != "2024-01-02T00:00:00Z" || p.Meta["owner"] != "me" {
+		t.Errorf("Expected the front matter to win but got %+v", p)
+	}
+	old, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "old"}})
+	if old.Tags != "side" || !old.Published {
+		t.Errorf("Expected the sidecars to still count but got %+v", old)
+	}
+
+	ti := fs.IndexTags(tagDir)
+	if !reflect.DeepEqual(ti["front"].Wikis, []string{"page"}) || !reflect.DeepEqual(ti["side"].Wikis, []string{"old"}) {
+		t.Errorf("Expected the tag index to use the front matter but got %+v", ti)
+	}
+	if pub := fs.getPublicPages(); !reflect.DeepEqual(pub, []string{"old"}) {
+		t.Errorf("Expected only old to be public but got %v", pub)
+	}
+}
+
+func TestSaveFrontMatterMode(t *testing.T) {
+	defer useTempWiki(t)()
+	defer func(original bool) { frontMatterMode = original }(frontMatterMode)
+	frontMatterMode = true
+
+	fs := fileStorage{TagDir: tagDir}
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+	setAliases(&cached, "page", []string{"other name"})
+
+	p := wikiPage{basePage: basePage{Title: "page"}, Body: "The body", Tags: "a, b", Published: true, Created: "2024-01-02", Meta: map[string]interface{}{"owner": "me"}}
+	if err := p.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := os.ReadFile(getWikiFilename(wikiDir, "page"))
+	expected := "---\ntitle: page\ntags: [a, b]\ncreated: \"2024-01-02T00:00:00Z\"\naliases: [other name]\npublished: true\nowner: me\n---\nThe body"
+	if string(raw) != expected {
+		t.Errorf("Expected %q but got %q", expected, raw)
+	}
+	for _, sidecar := range []string{getWikiTagsFilename("page"), getWikiPubFilename("page")} {
+		if _, err := os.Stat(sidecar); !os.IsNotExist(err) {
+			t.Errorf("Didn't expect %v to be written", sidecar)
+		}
+	}
+	if wikis := cached.GetTagWikis("b").Wikis; !reflect.DeepEqual(wikis, []string{"page"}) {
+		t.Errorf("Expected the cached tag index to pick up the front matter but got %v", wikis)
+	}
+	if pub := cached.getPublicPages(); !reflect.DeepEqual(pub, []string{"page"}) {
+		t.Errorf("Expected page to be public but got %v", pub)
+	}
+
+	// Encrypted pages keep their metadata outside the encrypted file
+	secret := wikiPage{basePage: basePage{Title: "secret"}, Body: "shh", Tags: "hidden", Encrypted: true}
+	if err := secret.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := os.ReadFile(getWikiTagsFilename("secret")); string(tags) != "hidden" {
+		t.Errorf("Expected an encrypted page's tags in its tags file but got %q", tags)
+	}
+}
+
+func TestSaveHandlerKeepsFrontMatter(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	os.WriteFile(getWikiFilename(wikiDir, "page"), []byte("---\ncreated: 2024-01-02\nowner: me\n---\nold body"), 0644)
+
+	form := url.Values{}
+	form.Add("body", "new body")
+	form.Add("wikitags", "x")
+	req := httptest.NewRequest("POST", "http://localhost/wiki/save/page", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	saveHandler(httptest.NewRecorder(), req, "page", &fs)
+
+	raw, _ := os.ReadFile(getWikiFilename(wikiDir, "page"))
+	if string(raw) != "---\ncreated: \"2024-01-02\"\nowner: me\n---\nnew body" {
+		t.Errorf("Expected the front matter to survive an edit but got %q", raw)
+	}
+	if dates, _ := readPageDates("page"); dates.Created.Format("2006-01-02") != "2024-01-02" {
+		t.Errorf("Expected the created date to move to the page's dates but got %v", dates.Created)
+	}
+	if tags, _ := os.ReadFile(getWikiTagsFilename("page")); string(tags) != "x" {
+		t.Errorf("Expected tags in the tags file but got %q", tags)
+	}
+}
+
+func TestAPISaveKeepsFrontMatter(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	os.WriteFile(getWikiFilename(wikiDir, "page"), []byte("---\ncreated: 2024-01-02\nowner: me\n---\nold body"), 0644)
+
+	req := httptest.NewRequest("POST", "http://localhost/api?wiki=page", strings.NewReader(`{"Title":"page","Body":"new body"}`))
+	w := httptest.NewRecorder()
+	innerAPIHandler(w, req, &fs)
+
+	p, err := fs.getPage(&wikiPage{basePage: basePage{Title: "page"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Body) != "new body" || p.Meta["owner"] != "me" || !strings.HasPrefix(p.Created, "2024-01-02") {
+		t.Errorf("Expected the front matter to survive an API save but got %+v", p)
+	}
+}
+
+func TestCachedPublicPages(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	os.WriteFile(getWikiFilename(wikiDir, "page"), []byte("---\npublished: true\n---\nbody"), 0644)
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+	if pub := cached.getPublicPages(); !reflect.DeepEqual(pub, []string{"page"}) {
+		t.Errorf("Expected page to be public but got %v", pub)
+	}
+
+	// Changes made through the cache are picked up without re-reading
+	// every page
+	cached.storeFile(getWikiFilename(wikiDir, "page"), []byte("---\npublished: false\n---\nbody"))
+	cached.storeFile(getWikiFilename(wikiDir, "other"), []byte("---\npublished: true\n---\nbody"))
+	if pub := cached.getPublicPages(); !reflect.DeepEqual(pub, []string{"other"}) {
+		t.Errorf("Expected only other to be public but got %v", pub)
+	}
+	cached.deleteFile(getWikiFilename(wikiDir, "other"))
+	if pub := cached.getPublicPages(); len(pub) != 0 {
+		t.Errorf("Expected no public pages but got %v", pub)
+	}
+}
+
+func TestMigrateMetadata(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	for _, p := range []wikiPage{
+		{basePage: basePage{Title: "folder/one"}, Body: "one", Tags: "a,b", Published: true},
+		{basePage: basePage{Title: "two"}, Body: "two"},
+		{basePage: basePage{Title: "secret"}, Body: "shh", Tags: "c", Encrypted: true},
+	} {
+		if err := p.save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setAliases(&fs, "two", []string{"deux"})
+
+	if _, err := migrateMetadata(&fs, "yaml"); err == nil {
+		t.Error("Expected an error migrating to an unknown format")
+	}
+
+	n, err := migrateMetadata(&fs, "frontmatter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 pages migrated but got %v", n)
+	}
+	if frontMatterMode {
+		t.Error("Expected migrating to leave the mode as it was")
+	}
+	one := readFrontMatter(getWikiFilename(wikiDir, "folder/one"))
+	if one == nil || one.Title != "folder/one" || !reflect.DeepEqual(one.Tags, []string{"a", "b"}) || one.Published == nil || one.Created == "" {
+		t.Errorf("folder/one not migrated as expected: %+v", one)
+	}
+	two := readFrontMatter(getWikiFilename(wikiDir, "two"))
+	if two == nil || two.Title != "two" || !reflect.DeepEqual(two.Aliases, []string{"deux"}) {
+		t.Errorf("two not migrated as expected: %+v", two)
+	}
+	if _, err := os.Stat(getWikiTagsFilename("folder/one")); !os.IsNotExist(err) {
+		t.Error("Expected the tags file to go")
+	}
+	if _, err := os.Stat(getWikiTagsFilename("secret")); err != nil {
+		t.Error("Expected the encrypted page to keep its tags file")
+	}
+	if n, _ := migrateMetadata(&fs, "frontmatter"); n != 0 {
+		t.Errorf("Expected nothing left to migrate but %v pages were", n)
+	}
+
+	// Hand written aliases get registered on the way back
+	os.WriteFile(getWikiFilename(wikiDir, "two"), []byte("---\ntitle: two\naliases: [deux, zwei]\n---\ntwo"), 0644)
+	if n, err := migrateMetadata(&fs, "sidecar"); err != nil || n != 2 {
+		t.Errorf("Expected 2 pages migrated back but got %v, %v", n, err)
+	}
+	if raw, _ := os.ReadFile(getWikiFilename(wikiDir, "folder/one")); string(raw) != "one" {
+		t.Errorf("Expected folder/one to have no front matter but got %q", raw)
+	}
+	p, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "folder/one"}})
+	if p.Tags != "a,b" || !p.Published {
+		t.Errorf("Expected the sidecars back but got %+v", p)
+	}
+	if aliases := fs.getAliases("two"); !reflect.DeepEqual(aliases, []string{"deux", "zwei"}) {
+		t.Errorf("Expected both aliases registered but got %v", aliases)
+	}
+}
+
+func TestViewShowsFrontMatter(t *testing.T) {
+	p := wikiPage{basePage: basePage{Title: "page"}, Body: template.HTML("body"), Meta: map[string]interface{}{"owner": "me"}}
+	w := httptest.NewRecorder()
+	renderTemplate(w, "view", &p)
+	if !strings.Contains(w.Body.String(), "<dt>owner</dt>") {
+		t.Errorf("Expected the extra front matter on the page but got %v", w.Body.String())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	bf "github.com/russross/blackfriday/v2"
+)
+
+// heading is a markdown heading along with the id blackfriday gives it and
+// the line of the body it starts on
+type heading struct {
+	Level int
+	Text  string
+	ID    string
+	Line  int
+}
+
+var atxHeading = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+var customHeadingID = regexp.MustCompile(`^(.*?)[ \t]*\{#([^}]+)\}$`)
+
+// pageHeadings finds the headings in a markdown body, skipping anything in
+// fenced code blocks.  IDs are worked out the same way blackfriday does with
+// AutoHeadingIDs so they match the anchors on the rendered page.
+func pageHeadings(body string) []heading {
+	var headings []heading
+	ids := map[string]int{}
+	fence := ""
+	prev := ""
+	for i, line := range splitLines(body) {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			prev = ""
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			prev = ""
+			continue
+		}
+
+		level, text, start := 0, "", i
+		if m := atxHeading.FindStringSubmatch(line); m != nil {
+			level, text = len(m[1]), m[2]
+		} else if prev != "" && trimmed != "" && strings.Trim(trimmed, "=") == "" {
+			level, text, start = 1, prev, i-1
+		} else if prev != "" && len(trimmed) > 1 && strings.Trim(trimmed, "-") == "" {
+			level, text, start = 2, prev, i-1
+		}
+
+		if level > 0 {
+			id := ""
+			if m := customHeadingID.FindStringSubmatch(text); m != nil {
+				text, id = m[1], m[2]
+			} else {
+				id = uniqueHeadingID(ids, bf.SanitizedAnchorName(text))
+			}
+			headings = append(headings, heading{Level: level, Text: text, ID: id, Line: start})
+			prev = ""
+			continue
+		}
+		prev = trimmed
+		if strings.HasPrefix(trimmed, "-") || strings.HasPrefix(trimmed, "*") || strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t") {
+			// List items and code can't be setext headings
+			prev = ""
+		}
+	}
+	return headings
+}
+
+// uniqueHeadingID follows blackfriday's scheme for repeated headings
+func uniqueHeadingID(ids map[string]int, id string) string {
+	for count, found := ids[id]; found; count, found = ids[id] {
+		tmp := fmt.Sprintf("%s-%d", id, count+1)
+		if _, tmpFound := ids[tmp]; !tmpFound {
+			ids[id] = count + 1
+			id = tmp
+		} else {
+			id = id + "-1"
+		}
+	}
+	if _, found := ids[id]; !found {
+		ids[id] = 0
+	}
+	return id
+}
+
+// hasHeading checks whether a link fragment, either an id or the heading
+// as written, is one of the headings
+func hasHeading(headings []heading, fragment string) bool {
+	return findHeading(headings, fragment) >= 0
+}
+
+// findHeading gives the index of the heading a link fragment names, or -1
+func findHeading(headings []heading, fragment string) int {
+	id := bf.SanitizedAnchorName(fragment)
+	for i, h := range headings {
+		if h.ID == fragment || h.ID == id {
+			return i
+		}
+	}
+	return -1
+}
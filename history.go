@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var histDir string
+
+// revision is a snapshot of a wiki page taken just before it is overwritten
+type revision struct {
+	ID        string
+	Title     string
+	Body      []byte
+	Tags      string
+	Published bool
+	Encrypted bool
+	Saved     time.Time
+	// front is the front matter that was on the page, split off its body
+	// when the revision is loaded
+	front *frontMatter
+}
+
+// SavedStr gives a display friendly version of the revision timestamp
+func (r revision) SavedStr() string {
+	return r.Saved.Format(TIME_FORMAT)
+}
+
+type historyPage struct {
+	basePage
+	Revisions []revision
+}
+
+// diffLine is a single line of a line level diff, Op is one of same, add or del
+type diffLine struct {
+	Op     string
+	Text   string
+	OldNum int
+	NewNum int
+}
+
+type diffPage struct {
+	basePage
+	From  string
+	To    string
+	Lines []diffLine
+}
+
+func getWikiHistoryDir(name string) string {
+	return histDir + name + "/"
+}
+
+// storeRevision copies the page as it currently is on disk into the history
+// folder.  The body is kept exactly as stored so encrypted pages stay encrypted.
+func (fst *fileStorage) storeRevision(title string) error {
+	filename := getWikiFilename(wikiDir, title)
+	body, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		// New page so nothing to keep
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	rev := revision{
+		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+		Title:     title,
+		Body:      body,
+		Encrypted: bytes.HasPrefix(body, encryptionFlag),
+		Saved:     info.ModTime(),
+	}
+	if tags, err := os.ReadFile(getWikiTagsFilename(title)); err == nil {
+		rev.Tags = string(tags)
+	}
+	if _, err := os.Stat(getWikiPubFilename(title)); err == nil {
+		rev.Published = true
+	}
+
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	return fst.storeFile(getWikiHistoryDir(title)+rev.ID+".json", data)
+}
+
+func readRevision(filename string) (revision, error) {
+	var rev revision
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return rev, err
+	}
+	err = json.Unmarshal(data, &rev)
+	return rev, err
+}
+
+// getRevisions lists the stored revisions for a page, newest first.  Bodies
+// are not returned.
+func (fst *fileStorage) getRevisions(title string) []revision {
+	files, err := os.ReadDir(getWikiHistoryDir(title))
+	if err != nil {
+		return []revision{}
+	}
+
+	revs := []revision{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		rev, err := readRevision(filepath.Join(getWikiHistoryDir(title), f.Name()))
+		if err != nil {
+			log.Printf("[history] skipping %v: %v", f.Name(), err)
+			continue
+		}
+		rev.Body = nil
+		revs = append(revs, rev)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].ID > revs[j].ID })
+	return revs
+}
+
+// getRevision loads a single revision with its body decrypted and any
+// front matter split off it
+func (fst *fileStorage) getRevision(title, id string) (revision, error) {
+	if strings.ContainsAny(id, "/\\.") {
+		return revision{}, fmt.Errorf("invalid revision id %q", id)
+	}
+	rev, err := readRevision(getWikiHistoryDir(title) + id + ".json")
+	if err != nil {
+		return rev, err
+	}
+	if bytes.HasPrefix(rev.Body, encryptionFlag) {
+		rev.Body, err = decrypt(bytes.TrimPrefix(rev.Body, encryptionFlag), ekey)
+		if err != nil {
+			return rev, err
+		}
+	}
+	rev.front, rev.Body = splitFrontMatter(rev.Body)
+	return rev, nil
+}
+
+// splitLines breaks a body into lines without a trailing empty line
+func splitLines(body string) []string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if body == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+}
+
+// diffLines does a line level diff of two bodies using the longest common
+// subsequence.  Common leading and trailing lines are trimmed first so that
+// the quadratic part only covers the region that actually changed.
+func diffLines(from, to string) []diffLine {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, diffLine{Op: "same", Text: a[i], OldNum: i + 1, NewNum: i + 1})
+	}
+
+	ma := a[prefix : len(a)-suffix]
+	mb := b[prefix : len(b)-suffix]
+
+	// lcs[i][j] holds the LCS length of ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			lines = append(lines, diffLine{Op: "same", Text: ma[i], OldNum: prefix + i + 1, NewNum: prefix + j + 1})
+			i++
+			j++
+		case j < len(mb) && (i == len(ma) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, diffLine{Op: "add", Text: mb[j], NewNum: prefix + j + 1})
+			j++
+		default:
+			lines = append(lines, diffLine{Op: "del", Text: ma[i], OldNum: prefix + i + 1})
+			i++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		lines = append(lines, diffLine{
+			Op:     "same",
+			Text:   a[len(a)-suffix+k],
+			OldNum: len(a) - suffix + k + 1,
+			NewNum: len(b) - suffix + k + 1,
+		})
+	}
+	return lines
+}
+
+// loadVersion returns the body of a revision, or the current page when no
+// revision id (or "current") is given
+func loadVersion(s storage, title, id string) (string, error) {
+	if id == "" || id == "current" {
+		p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+		if err != nil {
+			return "", err
+		}
+		return string(p.Body), nil
+	}
+	rev, err := s.getRevision(title, id)
+	if err != nil {
+		return "", err
+	}
+	return string(rev.Body), nil
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
+	hp := historyPage{basePage: p.basePage, Revisions: s.getRevisions(p.Title)}
+	renderTemplate(w, "history", hp)
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
+	from := r.FormValue("from")
+	to := r.FormValue("to")
+
+	fromBody, err := loadVersion(s, p.Title, from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	toBody, err := loadVersion(s, p.Title, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if from == "" {
+		from = "current"
+	}
+	if to == "" {
+		to = "current"
+	}
+	dp := diffPage{basePage: p.basePage, From: from, To: to, Lines: diffLines(fromBody, toBody)}
+	renderTemplate(w, "diff", dp)
+}
+
+// restoreHandler puts an old revision back by saving it as a new version so
+// that the page being replaced ends up in the history too.  Only a POST
+// restores so following a link can't roll a page back.
+func restoreHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Restoring needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.FormValue("rev")
+	if id == "" {
+		http.Error(w, "Form param 'rev' needs setting", http.StatusBadRequest)
+		return
+	}
+	rev, err := s.getRevision(p.Title, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	restored := wikiPage{
+		basePage:  basePage{Title: p.Title},
+		Body:      template.HTML(rev.Body),
+		Tags:      rev.Tags,
+		Published: rev.Published,
+		Encrypted: rev.Encrypted,
+		Author:    requestAuthor(r),
+	}
+	if rev.front != nil {
+		rev.front.apply(&restored)
+	}
+	if err := restored.save(s); err != nil {
+		log.Printf("Error restoring wiki page: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/wiki/view/"+p.Title, http.StatusFound)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	from := "one\ntwo\nthree\nfour"
+	to := "one\nthree\nfour\nfive"
+
+	lines := diffLines(from, to)
+
+	var ops []string
+	for _, l := range lines {
+		ops = append(ops, l.Op+":"+l.Text)
+	}
+	expected := "same:one,del:two,same:three,same:four,add:five"
+	if strings.Join(ops, ",") != expected {
+		t.Errorf("Expected %v but got %v", expected, strings.Join(ops, ","))
+	}
+	if lines[4].NewNum != 4 || lines[4].OldNum != 0 {
+		t.Errorf("Wrong line numbers for added line: %+v", lines[4])
+	}
+}
+
+func TestDiffLinesEmpty(t *testing.T) {
+	lines := diffLines("", "new line\n")
+	if len(lines) != 1 || lines[0].Op != "add" {
+		t.Errorf("Expected a single add but got %+v", lines)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	originalWikiDir := wikiDir
+	originalTagDir := tagDir
+	originalPubDir := pubDir
+	originalHistDir := histDir
+	originalEkey := ekey
+	wikiDir = tmpDir + "/"
+	tagDir = filepath.Join(tmpDir, "tags") + "/"
+	pubDir = filepath.Join(tmpDir, "pub") + "/"
+	histDir = filepath.Join(tmpDir, "history") + "/"
+	ekey = []byte("12345678901234567890123456789012")
+	defer func() {
+		wikiDir = originalWikiDir
+		tagDir = originalTagDir
+		pubDir = originalPubDir
+		histDir = originalHistDir
+		ekey = originalEkey
+	}()
+
+	fs := &fileStorage{TagDir: tagDir}
+
+	// Nothing to keep for a brand new page
+	p := wikiPage{basePage: basePage{Title: "folder/page"}, Body: "first", Tags: "one", Published: true}
+	if err := p.save(fs); err != nil {
+		t.Fatal(err)
+	}
+	if revs := fs.getRevisions("folder/page"); len(revs) != 0 {
+		t.Fatalf("Expected no revisions for a new page but got %v", len(revs))
+	}
+
+	p = wikiPage{basePage: basePage{Title: "folder/page"}, Body: "second", Encrypted: true}
+	if err := p.save(fs); err != nil {
+		t.Fatal(err)
+	}
+	p = wikiPage{basePage: basePage{Title: "folder/page"}, Body: "third"}
+	if err := p.save(fs); err != nil {
+		t.Fatal(err)
+	}
+
+	revs := fs.getRevisions("folder/page")
+	if len(revs) != 2 {
+		t.Fatalf("Expected 2 revisions but got %v", len(revs))
+	}
+
+	// Newest first so the encrypted one is at the top
+	newest, err := fs.getRevision("folder/page", revs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(newest.Body) != "second" || !newest.Encrypted {
+		t.Errorf("Expected decrypted 'second' but got %q (encrypted %v)", newest.Body, newest.Encrypted)
+	}
+
+	oldest, err := fs.getRevision("folder/page", revs[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(oldest.Body) != "first" || oldest.Tags != "one" || !oldest.Published {
+		t.Errorf("Revision lost page details: %+v", oldest)
+	}
+
+	if _, err := fs.getRevision("folder/page", "../../tags/folder/page"); err == nil {
+		t.Error("Expected an error for a revision id outside the history folder")
+	}
+}
+
+func TestRestoreHandler(t *testing.T) {
+	var saved []string
+	s := stubStorage{
+		getRevisionFunc: func(title, id string) (revision, error) {
+			return revision{ID: id, Title: title, Body: []byte("old body"), Tags: "old"}, nil
+		},
+		storeFileFunc: func(name string, content []byte) error {
+			saved = append(saved, name+"="+string(content))
+			return nil
+		},
+	}
+	p := wikiPage{basePage: basePage{Title: "test"}}
+	req := httptest.NewRequest("POST", "http://localhost/wiki/restore/test?rev=123", nil)
+	w := httptest.NewRecorder()
+
+	restoreHandler(w, req, &p, &s)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("Failed to get a 302 response, got %v", resp.StatusCode)
+	}
+	if len(saved) == 0 || !strings.HasSuffix(saved[0], "test.md=old body") {
+		t.Errorf("Expected the old body to be saved but got %v", saved)
+	}
+
+	saved = nil
+	w = httptest.NewRecorder()
+	restoreHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/restore/test?rev=123", nil), &p, &s)
+	if w.Code != http.StatusMethodNotAllowed || len(saved) != 0 {
+		t.Errorf("Expected a GET to be refused without saving, got %v and %v", w.Code, saved)
+	}
+}
+
+func TestDiffHandler(t *testing.T) {
+	s := stubStorage{
+		getPageFunc: func(pg *wikiPage) (*wikiPage, error) {
+			pg.Body = "new line"
+			return pg, nil
+		},
+		getRevisionFunc: func(title, id string) (revision, error) {
+			return revision{Body: []byte("old line")}, nil
+		},
+	}
+	p := wikiPage{basePage: basePage{Title: "test"}}
+	req := httptest.NewRequest("GET", "http://localhost/wiki/diff/test?from=123&to=current", nil)
+	w := httptest.NewRecorder()
+
+	diffHandler(w, req, &p, &s)
+
+	body := w.Body.String()
+	if w.Code != http.StatusOK {
+		t.Errorf("Failed to get a 200 response, got %v", w.Code)
+	}
+	if !strings.Contains(body, "diff-del") || !strings.Contains(body, "diff-add") {
+		t.Errorf("Expected both an add and a delete in the diff: %v", body)
+	}
+}
+
+func TestRestoreFrontMatterPage(t *testing.T) {
+	defer useTempWiki(t)()
+	defer func(original bool) { frontMatterMode = original }(frontMatterMode)
+	frontMatterMode = true
+	fs := fileStorage{TagDir: tagDir}
+
+	p := wikiPage{basePage: basePage{Title: "p"}, Body: "one", Tags: "a,b", Published: true, Meta: map[string]interface{}{"owner": "me"}}
+	if err := p.save(&fs); err != nil {
+		t.Fatal(err)
+	}
+	p = wikiPage{basePage: basePage{Title: "p"}, Body: "two", Tags: "c"}
+	if err := p.save(&fs); err != nil {
+		t.Fatal(err)
+	}
+
+	revs := fs.getRevisions("p")
+	if len(revs) != 1 {
+		t.Fatalf("Expected one revision but got %+v", revs)
+	}
+	if body, _ := loadVersion(&fs, "p", revs[0].ID); body != "one" {
+		t.Errorf("Expected the revision without its front matter but got %q", body)
+	}
+
+	w := httptest.NewRecorder()
+	restoreHandler(w, httptest.NewRequest("POST", "http://localhost/wiki/restore/p?rev="+revs[0].ID, nil), &wikiPage{basePage: basePage{Title: "p"}}, &fs)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect after restoring, got %v: %v", w.Code, w.Body.String())
+	}
+	restored, err := fs.getPage(&wikiPage{basePage: basePage{Title: "p"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Body != "one" || restored.Tags != "a,b" || !restored.Published || restored.Meta["owner"] != "me" {
+		t.Errorf("Expected the old page back but got %+v", restored)
+	}
+	if raw, _ := os.ReadFile(getWikiFilename(wikiDir, "p")); strings.Count(string(raw), "---\n") != 2 || !strings.Contains(string(raw), "tags: [a, b]\n") {
+		t.Errorf("Expected a single front matter block but got %q", raw)
+	}
+}
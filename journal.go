@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// journalPath is the Go time layout journal pages are named with and
+// journalTemplate the page new journal pages start as a copy of
+var journalPath = "Journal/2006/01/2006-01-02"
+var journalTemplate = "Templates/Journal"
+
+// journalDay is how days are written in journal URLs
+const journalDay = "2006-01-02"
+
+// validJournalPath checks a layout names each day's page uniquely
+func validJournalPath(layout string) bool {
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.Local)
+	parsed, err := time.ParseInLocation(layout, day.Format(layout), time.Local)
+	return err == nil && parsed.Equal(day)
+}
+
+// journalTitle is the page for a day
+func journalTitle(day time.Time) string {
+	return day.Format(journalPath)
+}
+
+// journalDate gives the day a journal page is for, ok is false for pages
+// that aren't journal pages
+func journalDate(title string) (time.Time, bool) {
+	day, err := time.ParseInLocation(journalPath, title, time.Local)
+	if err != nil || day.Format(journalPath) != title {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// journalEntries lists the days with journal pages, oldest first
+func journalEntries(s storage) []time.Time {
+	var days []time.Time
+	for _, n := range flattenWikis(s.IndexWikiFiles("", wikiDir)) {
+		if day, ok := journalDate(strings.TrimPrefix(n.URL, "/")); ok {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// journalNav links a journal page to the entries either side of it
+type journalNav struct {
+	Date string
+	Prev string
+	Next string
+}
+
+func newJournalNav(s storage, title string) *journalNav {
+	day, ok := journalDate(title)
+	if !ok {
+		return nil
+	}
+	jn := &journalNav{Date: day.Format(journalDay)}
+	for _, d := range journalEntries(s) {
+		switch {
+		case d.Before(day):
+			jn.Prev = journalTitle(d)
+		case d.After(day) && jn.Next == "":
+			jn.Next = journalTitle(d)
+		}
+	}
+	return jn
+}
+
+// journalBody is what a new journal page starts with, the journal template
+// with its placeholders filled in if there is one
+func journalBody(s storage, title string, day time.Time) (string, string) {
+	tmpl, err := s.getPage(&wikiPage{basePage: basePage{Title: journalTemplate}})
+	if err != nil {
+		return "# " + day.Format("Monday 2 January 2006") + "\n\n", ""
+	}
+	return fillTemplate(string(tmpl.Body), title, day), tmpl.Tags
+}
+
+func todayHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/wiki/journal/"+time.Now().Format(journalDay), http.StatusFound)
+}
+
+// calendarDay is a day in the journal calendar, Day is 0 for the blanks
+// before the first of the month
+type calendarDay struct {
+	Day   int
+	Title string
+}
+
+type calendarMonth struct {
+	Name  string
+	Weeks [][]calendarDay
+}
+
+type journalPage struct {
+	basePage
+	Today  string
+	Months []calendarMonth
+}
+
+// journalCalendar lays out the months with journal entries, newest first,
+// as weeks starting on Monday
+func journalCalendar(days []time.Time) []calendarMonth {
+	var months []calendarMonth
+	for i := len(days) - 1; i >= 0; {
+		first := time.Date(days[i].Year(), days[i].Month(), 1, 0, 0, 0, 0, time.Local)
+		entries := map[int]bool{}
+		for ; i >= 0 && days[i].Year() == first.Year() && days[i].Month() == first.Month(); i-- {
+			entries[days[i].Day()] = true
+		}
+
+		month := calendarMonth{Name: first.Format("January 2006")}
+		week := make([]calendarDay, (int(first.Weekday())+6)%7)
+		for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+			cd := calendarDay{Day: d.Day()}
+			if entries[d.Day()] {
+				cd.Title = journalTitle(d)
+			}
+			week = append(week, cd)
+			if len(week) == 7 {
+				month.Weeks = append(month.Weeks, week)
+				week = nil
+			}
+		}
+		if len(week) > 0 {
+			month.Weeks = append(month.Weeks, week)
+		}
+		months = append(months, month)
+	}
+	return months
+}
+
+// journalHandler shows the calendar of journal entries or, given a day,
+// opens that day's page.  A day without a page is opened for editing, where
+// it starts from the journal template.
+func journalHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
+	if p.Title == "" {
+		jp := journalPage{
+			basePage: basePage{Title: "Journal", Nav: p.Nav},
+			Today:    time.Now().Format(journalDay),
+			Months:   journalCalendar(journalEntries(s)),
+		}
+		renderTemplate(w, "journal", jp)
+		return
+	}
+
+	day, err := time.ParseInLocation(journalDay, p.Title, time.Local)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	title := journalTitle(day)
+	if _, err := s.getPage(&wikiPage{basePage: basePage{Title: title}}); err == nil {
+		http.Redirect(w, r, "/wiki/view/"+title, http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/wiki/edit/"+title, http.StatusFound)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalDates(t *testing.T) {
+	day := time.Date(2026, 10, 17, 0, 0, 0, 0, time.Local)
+	if title := journalTitle(day); title != "Journal/2026/10/2026-10-17" {
+		t.Errorf("Expected the default journal page name but got %v", title)
+	}
+	if d, ok := journalDate("Journal/2026/10/2026-10-17"); !ok || !d.Equal(day) {
+		t.Errorf("Expected the page to be for %v but got %v", day, d)
+	}
+	for _, title := range []string{"Journal/2026/10/notes", "Journal/2026/1/2026-1-17", "Other/2026-10-17"} {
+		if _, ok := journalDate(title); ok {
+			t.Errorf("Didn't expect %v to be a journal page", title)
+		}
+	}
+	for layout, valid := range map[string]bool{"Journal/2006/01/2006-01-02": true, "Diary/02 Jan 2006": true, "Journal/2006/01": false, "Journal": false} {
+		if validJournalPath(layout) != valid {
+			t.Errorf("Expected %v to be valid %v", layout, valid)
+		}
+	}
+}
+
+func TestJournalHandler(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	if err := (&wikiPage{basePage: basePage{Title: journalTemplate}, Body: "# {{date}}\n\nSee {{title}}\n", Tags: "journal"}).save(&fs); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	todayHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/today", nil))
+	if w.Header().Get("Location") != "/wiki/journal/"+time.Now().Format(journalDay) {
+		t.Errorf("Expected to be sent to today's journal but got %v", w.Header().Get("Location"))
+	}
+
+	open := func(day string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		journalHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/journal/"+day, nil), &wikiPage{basePage: basePage{Title: day}}, &fs)
+		return w
+	}
+	edit := func(title string) string {
+		w := httptest.NewRecorder()
+		editHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/edit/"+title, nil), &wikiPage{basePage: basePage{Title: title}}, &fs)
+		return w.Body.String()
+	}
+	w = open("2026-10-17")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/wiki/edit/Journal/2026/10/2026-10-17" {
+		t.Fatalf("Expected a new page to be opened for editing, got %v %v", w.Code, w.Header().Get("Location"))
+	}
+	if _, err := os.Stat(getWikiFilename(wikiDir, "Journal/2026/10/2026-10-17")); !os.IsNotExist(err) {
+		t.Errorf("Expected the page not to be saved until it is edited")
+	}
+	if out := edit("Journal/2026/10/2026-10-17"); !strings.Contains(out, "# 2026-10-17\n\nSee Journal/2026/10/2026-10-17\n") || !strings.Contains(out, `value="journal"`) {
+		t.Errorf("Expected the page from the template but got %v", out)
+	}
+	for _, title := range []string{"Journal/2026/10/2026-10-17", "Journal/2026/10/2026-10-12", "Journal/2026/11/2026-11-02"} {
+		if err := (&wikiPage{basePage: basePage{Title: title}, Body: "entry"}).save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w = open("2026-10-17"); w.Header().Get("Location") != "/wiki/view/Journal/2026/10/2026-10-17" {
+		t.Errorf("Expected an existing page to be viewed but got %v", w.Header().Get("Location"))
+	}
+	if w = open("17-10-2026"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a bad day, got %v", w.Code)
+	}
+
+	// Without a template the page gets the date as its heading
+	fs.deleteFile(getWikiFilename(wikiDir, journalTemplate))
+	if out := edit("Journal/2026/10/2026-10-05"); !strings.Contains(out, "# Monday 5 October 2026\n\n") {
+		t.Errorf("Expected the default journal page but got %v", out)
+	}
+
+	w = httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/Journal/2026/10/2026-10-17", nil), &wikiPage{basePage: basePage{Title: "Journal/2026/10/2026-10-17"}}, &fs)
+	out := w.Body.String()
+	if !strings.Contains(out, `href="/wiki/view/Journal/2026/10/2026-10-12">&larr; previous`) || !strings.Contains(out, `href="/wiki/view/Journal/2026/11/2026-11-02">next`) {
+		t.Errorf("Expected links to the entries either side but got %v", out)
+	}
+
+	w = open("")
+	out = w.Body.String()
+	if strings.Index(out, "November 2026") > strings.Index(out, "October 2026") || !strings.Contains(out, `<a href="/wiki/view/Journal/2026/10/2026-10-12">12</a>`) {
+		t.Errorf("Expected a calendar of the entries but got %v", out)
+	}
+}
+
+func TestJournalCalendar(t *testing.T) {
+	days := []time.Time{time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 10, 31, 0, 0, 0, 0, time.Local)}
+	months := journalCalendar(days)
+	if len(months) != 1 || months[0].Name != "October 2026" {
+		t.Fatalf("Expected one month but got %+v", months)
+	}
+	weeks := months[0].Weeks
+	// 1 October 2026 is a Thursday
+	if len(weeks) != 5 || weeks[0][3].Day != 1 || weeks[0][3].Title == "" || weeks[0][2].Day != 0 || weeks[4][5].Day != 31 || weeks[4][5].Title == "" || weeks[1][0].Title != "" {
+		t.Errorf("Calendar not laid out as expected: %+v", weeks)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// wikiWordRe matches wiki links such as {{Page}} and {{Folder/Page#heading}},
+// and pages included with {{!Page}}
+var wikiWordRe = regexp.MustCompile(`\{\{!?([^\}^#]+)[#]*([^\}]*)\}\}`)
+
+// parseLinks finds the pages the body of the page title links to, each
+// listed once in the order they first appear
+func parseLinks(title, body string) []string {
+	links := []string{}
+	for _, m := range wikiWordRe.FindAllStringSubmatch(body, -1) {
+		target := strings.TrimSpace(m[1])
+		if target != "" && !contains(target, links) && !isPlaceholder(title, m[0]) {
+			links = append(links, target)
+		}
+	}
+	return links
+}
+
+// linkResolver works out whether wiki links lead anywhere, using the nav
+// index for the list of pages and remembering the headings it has looked
+// up while a page is rendered
+type linkResolver struct {
+	s        storage
+	pages    map[string]bool
+	headings map[string][]heading
+}
+
+func newLinkResolver(s storage) *linkResolver {
+	lr := &linkResolver{s: s, pages: map[string]bool{}, headings: map[string][]heading{}}
+	for _, n := range flattenWikis(s.IndexWikiFiles("", wikiDir)) {
+		lr.pages[strings.TrimPrefix(n.URL, "/")] = true
+	}
+	return lr
+}
+
+// resolve gives the page a link ends up at, following an alias if need be,
+// or "" if there's no such page
+func (lr *linkResolver) resolve(title string) string {
+	if lr.pages[title] {
+		return title
+	}
+	if to := lr.s.getAlias(title); to != "" && lr.pages[to] {
+		return to
+	}
+	return ""
+}
+
+// hasHeading checks a heading on a page that is known to exist.  Pages that
+// can't be read as markdown, such as PDFs, are given the benefit of the doubt.
+func (lr *linkResolver) hasHeading(title, fragment string) bool {
+	hs, ok := lr.headings[title]
+	if !ok {
+		p, err := lr.s.getPage(&wikiPage{basePage: basePage{Title: title}})
+		if err != nil {
+			return true
+		}
+		hs = pageHeadings(string(p.Body))
+		lr.headings[title] = hs
+	}
+	return hasHeading(hs, fragment)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	links := parseLinks("page", "See {{Page One}} and {{Folder/Two#Some heading}}\nthen {{Page One}} again, {{ }} is nothing, {{!Included}}")
+	expected := []string{"Page One", "Folder/Two", "Included"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected %v but got %v", expected, links)
+	}
+}
+
+func TestParseWikiWordsTwoOnALine(t *testing.T) {
+	out := string(parseWikiWords([]byte("{{A}} and {{B#top}}"), "page", nil))
+	expected := `<a href="/wiki/view/A#">A</a> and <a href="/wiki/view/B#top">B</a>`
+	if out != expected {
+		t.Errorf("Expected %v but got %v", expected, out)
+	}
+}
+
+func TestParseWikiWordsResolved(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	(&wikiPage{basePage: basePage{Title: "folder/there"}, Body: "## Top bit"}).save(&fs)
+	(&wikiPage{basePage: basePage{Title: "named"}, Body: "body"}).save(&fs)
+	setAliases(&fs, "named", []string{"nick"})
+
+	out := string(parseWikiWords([]byte("{{folder/there#Top bit}} {{folder/there#top-bit}} {{folder/there#gone}} {{missing}} {{nick}}"), "page", newLinkResolver(&fs)))
+	for _, expected := range []string{
+		`<a href="/wiki/view/folder/there#Top bit">folder/there</a>`,
+		`<a href="/wiki/view/folder/there#top-bit">folder/there</a>`,
+		`<a class="wikilink-missing-heading" href="/wiki/view/folder/there#gone" title="folder/there has no heading gone">folder/there</a>`,
+		`<a class="wikilink-missing" href="/wiki/edit/missing" title="missing doesn't exist yet, click to create it">missing</a>`,
+		`<a href="/wiki/view/nick#">nick</a>`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %v in %v", expected, out)
+		}
+	}
+}
+
+func TestSearchIndexBacklinks(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	idx := newSearchIndex(filepath.Join(tmpDir, "search.gob"))
+	idx.add(indexedDoc{Title: "a", Links: []string{"c", "a"}}, "")
+	idx.add(indexedDoc{Title: "b", Links: []string{"c"}}, "")
+	idx.add(indexedDoc{Title: "secret", Links: []string{"c"}, Encrypted: true}, "")
+
+	if links := idx.backlinks("c"); !reflect.DeepEqual(links, []string{"a", "b", "secret"}) {
+		t.Errorf("Expected a, b and secret to link to c but got %v", links)
+	}
+	if links := idx.backlinks("a"); len(links) != 0 {
+		t.Errorf("Expected a page linking to itself to be left out but got %v", links)
+	}
+	if links := idx.links("a"); !reflect.DeepEqual(links, []string{"c", "a"}) {
+		t.Errorf("Expected the outgoing links of a but got %v", links)
+	}
+
+	idx.add(indexedDoc{Title: "b", Links: []string{"a"}}, "")
+	if links := idx.backlinks("c"); !reflect.DeepEqual(links, []string{"a", "secret"}) {
+		t.Errorf("Expected b's old link to be dropped but got %v", links)
+	}
+	idx.remove("a")
+	if links := idx.backlinks("c"); !reflect.DeepEqual(links, []string{"secret"}) {
+		t.Errorf("Expected a's links to be dropped but got %v", links)
+	}
+
+	// Links come back with the saved index, apart from encrypted pages
+	idx.save()
+	loaded := newSearchIndex(idx.file)
+	loaded.load()
+	if links := loaded.backlinks("a"); !reflect.DeepEqual(links, []string{"b"}) {
+		t.Errorf("Expected b to link to a after loading but got %v", links)
+	}
+	if links := loaded.backlinks("c"); len(links) != 0 {
+		t.Errorf("Expected encrypted links not to be saved but got %v", links)
+	}
+
+	// Indexes saved by an older version are ignored
+	f, err := os.Create(idx.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.NewEncoder(f).Encode(&searchIndex{Version: 1, Docs: loaded.Docs, Postings: loaded.Postings})
+	f.Close()
+	old := newSearchIndex(idx.file)
+	old.load()
+	if len(old.Docs) != 0 {
+		t.Errorf("Expected an old index to be ignored but got %v docs", len(old.Docs))
+	}
+}
+
+func TestViewHandlerBacklinks(t *testing.T) {
+	s := stubStorage{
+		getPageFunc: func(p *wikiPage) (*wikiPage, error) {
+			p.Body = template.HTML("body")
+			return p, nil
+		},
+		getBacklinksFunc: func(title string) []string {
+			return []string{"Other/page"}
+		},
+	}
+	req := httptest.NewRequest("GET", "http://localhost/wiki/view/test", nil)
+	w := httptest.NewRecorder()
+
+	viewHandler(w, req, &wikiPage{basePage: basePage{Title: "test"}}, &s)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Linked from") || !strings.Contains(body, `href="/wiki/view/Other/page"`) {
+		t.Errorf("Expected a linked from section: %v", body)
+	}
+}
+
+// TestViewHandlerWithoutLinkIndex checks a view doesn't read the whole wiki
+// for backlinks when there is no index to ask
+func TestViewHandlerWithoutLinkIndex(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	(&wikiPage{basePage: basePage{Title: "test"}, Body: "body"}).save(&fs)
+	(&wikiPage{basePage: basePage{Title: "other"}, Body: "{{test}}"}).save(&fs)
+
+	w := httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/test", nil), &wikiPage{basePage: basePage{Title: "test"}}, &fs)
+	if strings.Contains(w.Body.String(), "Linked from") {
+		t.Errorf("Expected no backlinks without a link index")
+	}
+
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+	cached.enableSearch("")
+	w = httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/test", nil), &wikiPage{basePage: basePage{Title: "test"}}, &cached)
+	if !strings.Contains(w.Body.String(), `href="/wiki/view/other"`) {
+		t.Errorf("Expected backlinks from the index: %v", w.Body.String())
+	}
+}
+
+func TestBacklinksApi(t *testing.T) {
+	s := stubStorage{
+		getBacklinksFunc: func(title string) []string {
+			return []string{title + " linker"}
+		},
+	}
+	req := httptest.NewRequest("GET", "http://localhost/api?backlinks=fred", nil)
+	w := httptest.NewRecorder()
+
+	innerAPIHandler(w, req, &s)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Failed to get a 200 response, got %v", w.Code)
+	}
+	var links []string
+	if err := json.Unmarshal(w.Body.Bytes(), &links); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(links, []string{"fred linker"}) {
+		t.Errorf("Expected the backlinks for fred but got %v", links)
+	}
+}
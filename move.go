@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// moveReport records what moving a page touched
+type moveReport struct {
+	basePage
+	From string
+	To   string
+	// Moved lists what was carried across, e.g. the tags or images
+	Moved []string
+	// Rewritten lists the pages whose links or image URLs were updated
+	Rewritten []string
+	// Failed lists pages that should have been updated but couldn't be
+	Failed []string
+}
+
+func getWikiImagesDir(name string) string {
+	return wikiDir + "images/" + name
+}
+
+// pageFile is one of the files that make up a page.  Only the page itself
+// has to be there.
+type pageFile struct {
+	name string
+	path string
+	// trash is what the file is called in the page's trash folder
+	trash string
+	// local files are kept out of the storage, e.g. git leaves out the
+	// history, so are moved directly
+	local bool
+}
+
+func pageFiles(title string) []pageFile {
+	return []pageFile{
+		{"page", getWikiFilename(wikiDir, title), "page.md", false},
+		{"tags", getWikiTagsFilename(title), "tags", false},
+		{"published marker", getWikiPubFilename(title), "pub", false},
+		{"dates", getWikiMetaFilename(title), "meta", false},
+		{"images", getWikiImagesDir(title), "images", false},
+		{"history", strings.TrimSuffix(getWikiHistoryDir(title), "/"), "history", true},
+	}
+}
+
+// move moves a page file through the storage, or straight on disk if it
+// is local
+func (f pageFile) move(s storage, from, to string) error {
+	if !f.local {
+		return s.moveFile(from, to)
+	}
+	if _, err := os.Stat(from); err != nil {
+		return err
+	}
+	if err := createDir(to); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// rewriteLinks points wiki links to from at to instead, keeping any
+// heading, and moves image URLs in from's images folder across
+func rewriteLinks(body, from, to string) string {
+	body = wikiWordRe.ReplaceAllStringFunc(body, func(link string) string {
+		m := wikiWordRe.FindStringSubmatch(link)
+		if strings.TrimSpace(m[1]) != from {
+			return link
+		}
+		open := "{{"
+		if strings.HasPrefix(link, "{{!") {
+			open = "{{!"
+		}
+		return open + to + strings.TrimPrefix(link[len(open):len(link)-2], m[1]) + "}}"
+	})
+	return strings.ReplaceAll(body, "/wiki/raw/images/"+from+"/", "/wiki/raw/images/"+to+"/")
+}
+
+// movePage renames a page along with its tags, published marker, images and
+// history.  The page's own image URLs are always fixed up, and when rewrite
+// is set so are links to it from other pages.  A page that is already there
+// is never replaced.
+func movePage(s storage, from, to string, rewrite bool) (*moveReport, error) {
+	report := &moveReport{From: from, To: to}
+	if !validTitle(to) {
+		return nil, fmt.Errorf("%q can't be used as a page name: %w", to, os.ErrInvalid)
+	}
+	if _, err := os.Stat(getWikiFilename(wikiDir, to)); err == nil {
+		return nil, fmt.Errorf("%v already exists: %w", to, os.ErrExist)
+	}
+
+	// Work out who links here before the page goes
+	var linkers []string
+	if rewrite {
+		linkers = s.getBacklinks(from)
+	}
+
+	dest := pageFiles(to)
+	for i, f := range pageFiles(from) {
+		err := f.move(s, f.path, dest[i].path)
+		if err == nil {
+			report.Moved = append(report.Moved, f.name)
+		} else if i == 0 {
+			return nil, err
+		} else if !os.IsNotExist(err) {
+			return report, err
+		}
+	}
+
+	if err := moveAliases(s, from, to); err != nil {
+		return report, err
+	}
+	if err := moveDraft(from, to); err != nil {
+		return report, err
+	}
+
+	for _, title := range append([]string{to}, linkers...) {
+		p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%v: %v", title, err))
+			continue
+		}
+		body := string(p.Body)
+		if title == to && !rewrite {
+			// Only the image URLs, a link to itself is left for the user
+			body = strings.ReplaceAll(body, "/wiki/raw/images/"+from+"/", "/wiki/raw/images/"+to+"/")
+		} else {
+			body = rewriteLinks(body, from, to)
+		}
+		if body == string(p.Body) {
+			continue
+		}
+		p.Body = template.HTML(body)
+		if err := p.save(s); err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%v: %v", title, err))
+			continue
+		}
+		report.Rewritten = append(report.Rewritten, title)
+	}
+	return report, nil
+}
+
+func moveHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
+	to := r.FormValue("to")
+	if to == "" {
+		http.Error(w, "Form param 'to' needs setting", http.StatusBadRequest)
+		return
+	}
+	rewrite := r.FormValue("rewrite") != ""
+
+	report, err := movePage(s, p.Title, to, rewrite)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, os.ErrInvalid):
+			status = http.StatusBadRequest
+		case errors.Is(err, os.ErrExist):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if len(report.Failed) > 0 {
+		log.Printf("Move of %v to %v couldn't update: %v", p.Title, to, report.Failed)
+	}
+	if !rewrite {
+		http.Redirect(w, r, "/wiki/view/"+to, http.StatusFound)
+		return
+	}
+
+	report.basePage = basePage{Title: "Moved " + p.Title, Nav: p.Nav}
+	renderTemplate(w, "move", report)
+}
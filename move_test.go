@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// useTempWiki points the wiki folders at a new temp dir, returning a func
+// that puts everything back and cleans up
+func useTempWiki(t *testing.T) func() {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originalWikiDir := wikiDir
+	originalTagDir := tagDir
+	originalPubDir := pubDir
+	originalHistDir := histDir
+	originalAliasDir := aliasDir
+	originalTrashDir := trashDir
+	originalEkey := ekey
+	originalSpecialDir := specialDir
+	wikiDir = tmpDir + "/"
+	tagDir = wikiDir + "tags/"
+	pubDir = wikiDir + "pub/"
+	histDir = wikiDir + "history/"
+	aliasDir = wikiDir + "aliases/"
+	trashDir = wikiDir + "trash/"
+	ekey = []byte("12345678901234567890123456789012")
+	specialDir = []string{"tags", "pub", "history", "aliases", "trash", "meta", "drafts"}
+	os.MkdirAll(tagDir, 0755)
+	os.MkdirAll(pubDir, 0755)
+
+	return func() {
+		wikiDir = originalWikiDir
+		tagDir = originalTagDir
+		pubDir = originalPubDir
+		histDir = originalHistDir
+		aliasDir = originalAliasDir
+		trashDir = originalTrashDir
+		ekey = originalEkey
+		specialDir = originalSpecialDir
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	body := "{{Old}} {{ Old }} {{Old#Some heading}} {{!Old#Part}} {{Older}} {{Dir/Old}}\n![pic](/wiki/raw/images/Old/1.png) /wiki/raw/images/Older/2.png"
+	expected := "{{New/Name}} {{New/Name}} {{New/Name#Some heading}} {{!New/Name#Part}} {{Older}} {{Dir/Old}}\n![pic](/wiki/raw/images/New/Name/1.png) /wiki/raw/images/Older/2.png"
+	if out := rewriteLinks(body, "Old", "New/Name"); out != expected {
+		t.Errorf("Expected %q but got %q", expected, out)
+	}
+}
+
+func TestMovePage(t *testing.T) {
+	defer useTempWiki(t)()
+
+	fs := fileStorage{TagDir: tagDir}
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+	cached.enableSearch("")
+
+	pages := []wikiPage{
+		{basePage: basePage{Title: "old"}, Body: "![pic](/wiki/raw/images/old/1.png) and {{old#top}}", Tags: "a,b", Published: true},
+		{basePage: basePage{Title: "linker"}, Body: "see {{old}} and {{old#top}}"},
+		{basePage: basePage{Title: "folder/secret"}, Body: "secretly {{old}}", Encrypted: true},
+		{basePage: basePage{Title: "unrelated"}, Body: "{{other}}"},
+	}
+	for _, p := range pages {
+		if err := p.save(&cached); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(getWikiImagesDir("old"), 0755)
+	os.WriteFile(getWikiImagesDir("old")+"/1.png", []byte("png"), 0644)
+
+	form := url.Values{}
+	form.Add("to", "new/place")
+	form.Add("rewrite", "on")
+	req := httptest.NewRequest("POST", "http://localhost/wiki/move/old", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	moveHandler(w, req, &wikiPage{basePage: basePage{Title: "old"}}, &cached)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected a 200 report, got %v: %v", w.Code, w.Body.String())
+	}
+	report := w.Body.String()
+	for _, expected := range []string{"tags", "published marker", "images", "/wiki/view/linker", "/wiki/view/folder/secret", "/wiki/view/new/place"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected %v in the report: %v", expected, report)
+		}
+	}
+	if strings.Contains(report, "unrelated") {
+		t.Errorf("Didn't expect unrelated in the report: %v", report)
+	}
+
+	for _, gone := range []string{getWikiFilename(wikiDir, "old"), getWikiTagsFilename("old"), getWikiPubFilename("old"), getWikiImagesDir("old")} {
+		if _, err := os.Stat(gone); !os.IsNotExist(err) {
+			t.Errorf("Expected %v to have moved", gone)
+		}
+	}
+	if _, err := os.Stat(getWikiImagesDir("new/place") + "/1.png"); err != nil {
+		t.Errorf("Expected the image to have moved: %v", err)
+	}
+
+	moved, err := cached.getPage(&wikiPage{basePage: basePage{Title: "new/place"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Body != "![pic](/wiki/raw/images/new/place/1.png) and {{new/place#top}}" || moved.Tags != "a,b" || !moved.Published {
+		t.Errorf("Moved page not as expected: %+v", moved)
+	}
+	for title, expected := range map[string]string{
+		"linker":        "see {{new/place}} and {{new/place#top}}",
+		"folder/secret": "secretly {{new/place}}",
+		"unrelated":     "{{other}}",
+	} {
+		p, err := cached.getPage(&wikiPage{basePage: basePage{Title: title}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p.Body) != expected {
+			t.Errorf("Expected %v to be %q but got %q", title, expected, p.Body)
+		}
+	}
+	secret, _ := os.ReadFile(getWikiFilename(wikiDir, "folder/secret"))
+	if strings.Contains(string(secret), "secretly") {
+		t.Error("Encrypted page was saved unencrypted")
+	}
+
+	links := cached.getBacklinks("new/place")
+	if len(links) != 2 || len(cached.getBacklinks("old")) != 0 {
+		t.Errorf("Expected the link index to follow the move but got %v", links)
+	}
+
+	// Moving the images folder triggers a background cache rebuild
+	cached.rebuilds.Wait()
+}
+
+func TestMovePageWithoutRewrite(t *testing.T) {
+	defer useTempWiki(t)()
+
+	fs := fileStorage{TagDir: tagDir}
+	(&wikiPage{basePage: basePage{Title: "old"}, Body: "{{old}} /wiki/raw/images/old/1.png"}).save(&fs)
+	(&wikiPage{basePage: basePage{Title: "linker"}, Body: "{{old}}"}).save(&fs)
+
+	report, err := movePage(&fs, "old", "new", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Moved) != 3 || len(report.Rewritten) != 1 || report.Rewritten[0] != "new" {
+		t.Errorf("Expected only the page, its tags and dates to move, and its image URLs fixed: %+v", report)
+	}
+	p, _ := fs.getPage(&wikiPage{basePage: basePage{Title: "new"}})
+	if p.Body != "{{old}} /wiki/raw/images/new/1.png" {
+		t.Errorf("Unexpected body after move: %v", p.Body)
+	}
+	p, _ = fs.getPage(&wikiPage{basePage: basePage{Title: "linker"}})
+	if p.Body != "{{old}}" {
+		t.Errorf("Expected links to be left alone but got %v", p.Body)
+	}
+}
+
+func TestMovePageRefused(t *testing.T) {
+	defer useTempWiki(t)()
+
+	fs := fileStorage{TagDir: tagDir}
+	for _, p := range []wikiPage{
+		{basePage: basePage{Title: "old"}, Body: "old body", Tags: "a"},
+		{basePage: basePage{Title: "taken"}, Body: "taken body", Tags: "b"},
+	} {
+		if err := p.save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	move := func(to string) int {
+		form := url.Values{}
+		form.Add("to", to)
+		req := httptest.NewRequest("POST", "http://localhost/wiki/move/old", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		moveHandler(w, req, &wikiPage{basePage: basePage{Title: "old"}}, &fs)
+		return w.Code
+	}
+	if code := move("taken"); code != http.StatusConflict {
+		t.Errorf("Expected moving onto a page to conflict but got %v", code)
+	}
+	for _, to := range []string{"../../outside", "folder/../../outside", "/abs", "folder//page", "bad<name>"} {
+		if code := move(to); code != http.StatusBadRequest {
+			t.Errorf("Expected %q to be refused but got %v", to, code)
+		}
+	}
+	if _, err := os.Stat(wikiDir + "../outside.md"); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written outside the wiki")
+	}
+
+	for title, expected := range map[string]string{"old": "old body", "taken": "taken body"} {
+		p, err := fs.getPage(&wikiPage{basePage: basePage{Title: title}})
+		if err != nil || string(p.Body) != expected {
+			t.Errorf("Expected %v to be left alone but got %+v", title, p)
+		}
+	}
+}
+
+func TestMovePageHistory(t *testing.T) {
+	defer useTempWiki(t)()
+
+	fs := fileStorage{TagDir: tagDir}
+	p := &wikiPage{basePage: basePage{Title: "old"}, Body: "first"}
+	p.save(&fs)
+	p.Body = "second"
+	p.save(&fs)
+	before := fs.getRevisions("old")
+
+	if _, err := movePage(&fs, "old", "folder/new", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.getRevisions("old")) != 0 {
+		t.Error("Expected no revisions left under the old name")
+	}
+	if after := fs.getRevisions("folder/new"); len(after) == 0 || len(after) != len(before) {
+		t.Errorf("Expected the revisions to move from %v but got %v", before, after)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -15,12 +16,17 @@ type wikiNav struct {
 	Mod     time.Time
 	ModStr  string
 	Summary string
+	file    string
 }
 type nav struct {
 	Pages   []string
 	Wikis   []wikiNav
 	Tags    TagIndex
 	Recents []wikiNav
+	// Summaries holds each page's summary by title for the tag listings
+	Summaries map[string]string
+	// Templates are the names of the pages new pages can start from
+	Templates []string
 }
 
 type navFunc func(storage) nav
@@ -73,9 +79,22 @@ func getNav(s storage) nav {
 	log.Printf("[nav] wikis %v", loadwikis.Sub(start))
 	log.Printf("[nav] tags %v", loadtags.Sub(loadwikis))
 	log.Printf("[nav] idxtags %v", indexTags.Sub(loadtags))
+	recents := genRecents(wikis)
+	summaries := make(map[string]string, len(recents))
+	var templates []string
+	for _, w := range recents {
+		title := strings.TrimPrefix(w.URL, "/")
+		summaries[title] = w.Summary
+		if isTemplate(title) && strings.HasSuffix(w.file, ".md") {
+			templates = append(templates, strings.TrimPrefix(title, templateFolder))
+		}
+	}
+	sort.Strings(templates)
 	return nav{
-		Wikis:   wikis,
-		Tags:    indexedTags,
-		Recents: genRecents(wikis),
+		Wikis:     wikis,
+		Tags:      indexedTags,
+		Recents:   recents,
+		Summaries: summaries,
+		Templates: templates,
 	}
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"html/template"
+	"path"
+	"strings"
+	"time"
+)
+
+// templateFolder holds the pages new pages can start as a copy of
+const templateFolder = "Templates/"
+
+// templatePlaceholders are filled in when a page is made from a template
+var templatePlaceholders = []string{"{{date}}", "{{title}}", "{{folder}}"}
+
+// fillTemplate replaces the placeholders in a template for a new page
+func fillTemplate(body, title string, now time.Time) string {
+	folder, _ := path.Split(title)
+	r := strings.NewReplacer(
+		"{{date}}", now.Format(journalDay),
+		"{{title}}", title,
+		"{{folder}}", strings.TrimSuffix(folder, "/"),
+	)
+	return r.Replace(body)
+}
+
+// isTemplate checks whether a page is one of the templates
+func isTemplate(title string) bool {
+	return strings.HasPrefix(title, templateFolder)
+}
+
+// isPlaceholder checks whether a {{link}} on a page is really one of the
+// placeholders, which it is on a template
+func isPlaceholder(title, link string) bool {
+	return isTemplate(title) && contains(link, templatePlaceholders)
+}
+
+// defaultTemplate gives the template for new pages in a page's folder, the
+// one named after the nearest folder it is in, or "" if there isn't one
+func defaultTemplate(s storage, title string) string {
+	for dir := path.Dir(title); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, err := s.getPage(&wikiPage{basePage: basePage{Title: templateFolder + dir}}); err == nil {
+			return strings.TrimPrefix(dir, "/")
+		}
+	}
+	return ""
+}
+
+// fromTemplate fills in a new page from a template, the one named or the
+// default for the page's folder, or for a journal page the journal
+// template.  The page is left alone if there is no such template.
+func (p *wikiPage) fromTemplate(s storage, name string, now time.Time) {
+	if day, ok := journalDate(p.Title); ok && name == "" {
+		body, tags := journalBody(s, p.Title, day)
+		p.Body = template.HTML(body)
+		if tags != "" {
+			p.Tags = tags
+			p.TagArray = strings.Split(tags, ",")
+		}
+		return
+	}
+	if name == "" {
+		name = defaultTemplate(s, p.Title)
+	}
+	if name == "" {
+		return
+	}
+	tmpl, err := s.getPage(&wikiPage{basePage: basePage{Title: templateFolder + name}})
+	if err != nil {
+		return
+	}
+	p.Body = template.HTML(fillTemplate(string(tmpl.Body), p.Title, now))
+	p.Tags = tmpl.Tags
+	p.TagArray = tmpl.TagArray
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFillTemplate(t *testing.T) {
+	now := time.Date(2026, 10, 17, 9, 30, 0, 0, time.Local)
+	for _, tc := range []struct {
+		title    string
+		expected string
+	}{
+		{"Projects/Wiki/Plan", "# Projects/Wiki/Plan\n\nStarted 2026-10-17 in Projects/Wiki\n"},
+		{"Plan", "# Plan\n\nStarted 2026-10-17 in \n"},
+	} {
+		if got := fillTemplate("# {{title}}\n\nStarted {{date}} in {{folder}}\n", tc.title, now); got != tc.expected {
+			t.Errorf("Expected %q for %v but got %q", tc.expected, tc.title, got)
+		}
+	}
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	for _, title := range []string{"Templates/Projects", "Templates/Work/Wiki"} {
+		if err := (&wikiPage{basePage: basePage{Title: title}, Body: "template"}).save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for title, expected := range map[string]string{
+		"Projects/Plan":        "Projects",
+		"Projects/Wiki/Plan":   "Projects",
+		"Work/Wiki/Notes/Todo": "Work/Wiki",
+		"Work/Plan":            "",
+		"Projects":             "",
+	} {
+		if got := defaultTemplate(&fs, title); got != expected {
+			t.Errorf("Expected %v to use template %q but got %q", title, expected, got)
+		}
+	}
+}
+
+func TestEditFromTemplate(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	for _, p := range []wikiPage{
+		{basePage: basePage{Title: "Templates/Meeting"}, Body: "# {{title}}\n\nHeld {{date}}\n", Tags: "meeting,notes"},
+		{basePage: basePage{Title: "Templates/Recipes"}, Body: "# {{title}}\n\n## Ingredients\n", Tags: "recipe"},
+		{basePage: basePage{Title: "Recipes/Soup"}, Body: "Already written"},
+	} {
+		if err := p.save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edit := func(target string) string {
+		w := httptest.NewRecorder()
+		editHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/edit/"+target, nil), &wikiPage{basePage: basePage{Title: strings.Split(target, "?")[0]}}, &fs)
+		return w.Body.String()
+	}
+	today := time.Now().Format(journalDay)
+	if out := edit("Standup?template=Meeting"); !strings.Contains(out, "# Standup\n\nHeld "+today) || !strings.Contains(out, `value="meeting,notes"`) {
+		t.Errorf("Expected the new page to start from the chosen template but got %v", out)
+	}
+	if out := edit("Recipes/Stew"); !strings.Contains(out, "# Recipes/Stew\n\n## Ingredients") || !strings.Contains(out, `value="recipe"`) {
+		t.Errorf("Expected the new page to start from its folder's template but got %v", out)
+	}
+	if out := edit("Recipes/Stew?template=Missing"); strings.Contains(out, "Ingredients") {
+		t.Errorf("Expected a missing template to leave the page empty but got %v", out)
+	}
+	if out := edit("Recipes/Soup?template=Meeting"); !strings.Contains(out, "Already written") || strings.Contains(out, "Held") {
+		t.Errorf("Expected an existing page to be left alone but got %v", out)
+	}
+	if _, err := os.Stat(getWikiFilename(wikiDir, "Recipes/Stew")); !os.IsNotExist(err) {
+		t.Errorf("Expected the new page not to be saved until it is edited")
+	}
+
+	if n := getNav(&fs); !reflect.DeepEqual(n.Templates, []string{"Meeting", "Recipes"}) {
+		t.Errorf("Expected the templates to be listed but got %v", n.Templates)
+	}
+}
+
+func TestViewTemplate(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+	cached.enableSearch("")
+	tmpl := wikiPage{basePage: basePage{Title: "Templates/Meeting"}, Body: "# {{title}}\n\nHeld {{date}} in {{folder}}, see {{Agenda}}\n"}
+	if err := tmpl.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/Templates/Meeting", nil), &wikiPage{basePage: basePage{Title: "Templates/Meeting"}}, &cached)
+	out := w.Body.String()
+	if !strings.Contains(out, "{{title}}") || !strings.Contains(out, "Held {{date}} in {{folder}}") || !strings.Contains(out, `href="/wiki/edit/Agenda"`) {
+		t.Errorf("Expected the placeholders as written and other links as links but got %v", out)
+	}
+	if links := cached.getLinks("Templates/Meeting"); !reflect.DeepEqual(links, []string{"Agenda"}) {
+		t.Errorf("Expected only the real link from the template but got %v", links)
+	}
+	if links := cached.getBacklinks("title"); len(links) != 0 {
+		t.Errorf("Didn't expect a placeholder to count as a link but got %v", links)
+	}
+
+	// Elsewhere they are ordinary links
+	if links := parseLinks("Notes", "{{date}}"); !reflect.DeepEqual(links, []string{"date"}) {
+		t.Errorf("Expected a link outside the templates but got %v", links)
+	}
+}
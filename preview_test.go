@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func previewPage(t *testing.T, s storage, title, body string) string {
+	data, _ := json.Marshal(map[string]string{"Body": body})
+	w := httptest.NewRecorder()
+	innerAPIHandler(w, httptest.NewRequest("POST", "http://localhost/api?preview="+title, bytes.NewReader(data)), s)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected a preview, got %v: %v", w.Code, w.Body.String())
+	}
+	var got map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	return got["html"]
+}
+
+func TestPreviewMatchesView(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	(&wikiPage{basePage: basePage{Title: "other"}, Body: "Included words"}).save(&fs)
+
+	body := "## Heading\r\n\r\nSee {{other}} and {{missing}}\n\n{{!other}}\n\n<script>alert(1)</script>"
+	if err := (&wikiPage{basePage: basePage{Title: "page"}, Body: template.HTML(body)}).save(&fs); err != nil {
+		t.Fatal(err)
+	}
+	preview := previewPage(t, &fs, "page", body)
+
+	w := httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/page", nil), &wikiPage{basePage: basePage{Title: "page"}}, &fs)
+	if !strings.Contains(w.Body.String(), preview) {
+		t.Errorf("Expected the preview %v to match the view %v", preview, w.Body.String())
+	}
+	for _, want := range []string{`href="/wiki/view/other#"`, "wikilink-missing", "Included words", "section=heading"} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("Expected %v in the preview but got %v", want, preview)
+		}
+	}
+	if strings.Contains(preview, "<script>") {
+		t.Errorf("Expected the preview to be sanitized but got %v", preview)
+	}
+	if _, err := readDraft("page"); err == nil {
+		t.Error("Didn't expect a preview to store anything")
+	}
+
+	if preview := previewPage(t, &fs, "page", "# One\n\n## Two"); !strings.Contains(preview, `<div class="toc">`) {
+		t.Errorf("Expected the contents in the preview but got %v", preview)
+	}
+}
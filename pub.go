@@ -25,8 +25,17 @@ func makePubHandler(fn func(http.ResponseWriter, *http.Request, *wikiPage, stora
 func pubHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
 	p, err := convertMarkdown(s.getPage(p))
 	if err != nil {
+		// Only send people on to pages that are published themselves
+		if to := s.getAlias(p.Title); to != "" {
+			if target, err := s.getPage(&wikiPage{basePage: basePage{Title: to}}); err == nil && target.Published {
+				http.Redirect(w, r, "/pub/"+to, http.StatusMovedPermanently)
+				return
+			}
+		}
 	} else {
-		p.Body = template.HTML(parseWikiWords([]byte(p.Body)))
+		// Public readers can't create pages so links are left as they are
+		body := transclude(s, []byte(p.Body), true, []string{p.Title})
+		p.Body = template.HTML(parseWikiWords(body, p.Title, nil))
 	}
 
 	renderTemplate(w, "pub", p)
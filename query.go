@@ -0,0 +1,466 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// query is a parsed search.  Words must all appear in a page unless joined
+// with OR, and can be grouped with brackets, negated with NOT or a leading
+// -, or quoted to match a phrase.  Filters narrow results on the page's
+// details rather than its text:
+//
+//	tag:recipes  path:Projects/  title:plan  modified:>2026-01-01
+//	is:published  is:encrypted  /regular expression/
+type query struct {
+	root queryNode
+	// terms and patterns are the positive parts of the query, used to rank
+	// results and pick the snippet shown for each
+	terms    []string
+	patterns []*regexp.Regexp
+}
+
+// queryNode is one part of a parsed query
+type queryNode interface {
+	match(qc *queryContext) bool
+}
+
+// queryContext is the page a query is being checked against.  The body is
+// only read if a regex needs it.
+type queryContext struct {
+	idx  *searchIndex
+	s    storage
+	doc  *indexedDoc
+	body *string
+}
+
+func (qc *queryContext) text() string {
+	if qc.body == nil {
+		body := ""
+		if p, err := qc.s.getPage(&wikiPage{basePage: basePage{Title: qc.doc.Title}}); err == nil {
+			body = string(p.Body)
+		}
+		qc.body = &body
+	}
+	return *qc.body
+}
+
+type andNode []queryNode
+
+func (n andNode) match(qc *queryContext) bool {
+	for _, c := range n {
+		if !c.match(qc) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []queryNode
+
+func (n orNode) match(qc *queryContext) bool {
+	for _, c := range n {
+		if c.match(qc) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct {
+	node queryNode
+}
+
+func (n notNode) match(qc *queryContext) bool {
+	return !n.node.match(qc)
+}
+
+// termNode is a single stemmed word found in the body or title
+type termNode string
+
+func (n termNode) match(qc *queryContext) bool {
+	if _, ok := qc.idx.Postings[string(n)][qc.doc.Title]; ok {
+		return true
+	}
+	return contains(string(n), qc.doc.TitleTerms)
+}
+
+// phraseNode is a run of stemmed words that must appear next to each other
+type phraseNode []string
+
+func (n phraseNode) match(qc *queryContext) bool {
+	if hasRun(qc.doc.TitleTerms, n) {
+		return true
+	}
+	first := qc.idx.Postings[n[0]][qc.doc.Title]
+next:
+	for _, pos := range first {
+		for i, term := range n[1:] {
+			positions := qc.idx.Postings[term][qc.doc.Title]
+			// Positions are added in order so are already sorted
+			j := sort.SearchInts(positions, pos+i+1)
+			if j == len(positions) || positions[j] != pos+i+1 {
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func hasRun(terms, run []string) bool {
+	for i := 0; i+len(run) <= len(terms); i++ {
+		if strings.Join(terms[i:i+len(run)], " ") == strings.Join(run, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+type tagNode string
+
+func (n tagNode) match(qc *queryContext) bool {
+	for _, t := range qc.doc.Tags {
+		if strings.EqualFold(t, string(n)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathNode matches pages whose title, including folders, starts with it
+type pathNode string
+
+func (n pathNode) match(qc *queryContext) bool {
+	return strings.HasPrefix(strings.ToLower(qc.doc.Title), strings.ToLower(string(n)))
+}
+
+type titleNode string
+
+func (n titleNode) match(qc *queryContext) bool {
+	return strings.Contains(strings.ToLower(qc.doc.Title), strings.ToLower(string(n)))
+}
+
+type isNode string
+
+func (n isNode) match(qc *queryContext) bool {
+	if n == "published" {
+		return qc.doc.Published
+	}
+	return qc.doc.Encrypted
+}
+
+// modifiedNode compares the day a page was last modified with a date
+type modifiedNode struct {
+	op   string
+	date time.Time
+}
+
+func (n modifiedNode) match(qc *queryContext) bool {
+	mod := qc.doc.Changed
+	next := n.date.AddDate(0, 0, 1)
+	switch n.op {
+	case ">":
+		return !mod.Before(next)
+	case ">=":
+		return !mod.Before(n.date)
+	case "<":
+		return mod.Before(n.date)
+	case "<=":
+		return mod.Before(next)
+	}
+	return !mod.Before(n.date) && mod.Before(next)
+}
+
+type regexNode struct {
+	re *regexp.Regexp
+}
+
+func (n regexNode) match(qc *queryContext) bool {
+	return n.re.MatchString(qc.doc.Title) || n.re.MatchString(qc.text())
+}
+
+type queryTokenKind int
+
+const (
+	tokWord queryTokenKind = iota
+	tokPhrase
+	tokRegex
+	tokOpen
+	tokClose
+	tokNot
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+}
+
+// lexQuery splits a query into words, quoted phrases, regexes and brackets.
+// A field can take a quoted value, e.g. tag:"two words".
+func lexQuery(q string) ([]queryToken, error) {
+	var toks []queryToken
+	i := 0
+	for i < len(q) {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, queryToken{tokOpen, "("})
+			i++
+		case c == ')':
+			toks = append(toks, queryToken{tokClose, ")"})
+			i++
+		case c == '-' && i+1 < len(q) && !strings.ContainsRune(" \t\n\r)", rune(q[i+1])):
+			toks = append(toks, queryToken{tokNot, "-"})
+			i++
+		case c == '"':
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("missing closing quote")
+			}
+			toks = append(toks, queryToken{tokPhrase, q[i+1 : i+1+end]})
+			i += end + 2
+		case c == '/':
+			end := i + 1
+			for end < len(q) && q[end] != '/' {
+				if q[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(q) {
+				return nil, errors.New("missing closing / on regex")
+			}
+			toks = append(toks, queryToken{tokRegex, q[i+1 : end]})
+			i = end + 1
+		default:
+			start := i
+			for i < len(q) && !strings.ContainsRune(" \t\n\r()", rune(q[i])) {
+				if q[i] == ':' && i+1 < len(q) && q[i+1] == '"' {
+					end := strings.IndexByte(q[i+2:], '"')
+					if end < 0 {
+						return nil, errors.New("missing closing quote")
+					}
+					toks = append(toks, queryToken{tokWord, q[start:i+1] + q[i+2:i+2+end]})
+					i += end + 3
+					start = -1
+					break
+				}
+				i++
+			}
+			if start >= 0 {
+				toks = append(toks, queryToken{tokWord, q[start:i]})
+			}
+		}
+	}
+	return toks, nil
+}
+
+type queryParser struct {
+	toks []queryToken
+	pos  int
+}
+
+// parseQuery turns the text typed into the search box into a query
+func parseQuery(q string) (*query, error) {
+	toks, err := lexQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	p := queryParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if root == nil {
+		return nil, errors.New("nothing to search for")
+	}
+	parsed := &query{root: root}
+	parsed.collect(root)
+	return parsed, nil
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos < len(p.toks) {
+		return &p.toks[p.pos]
+	}
+	return nil
+}
+
+func (p *queryParser) isWord(w string) bool {
+	t := p.peek()
+	return t != nil && t.kind == tokWord && t.text == w
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	var nodes orNode
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+		if !p.isWord("OR") {
+			break
+		}
+		p.pos++
+	}
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes andNode
+	for {
+		t := p.peek()
+		if t == nil || t.kind == tokClose || p.isWord("OR") {
+			break
+		}
+		if p.isWord("AND") {
+			p.pos++
+			continue
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	t := p.peek()
+	if t.kind == tokNot || p.isWord("NOT") {
+		p.pos++
+		if p.peek() == nil {
+			return nil, errors.New("nothing after NOT")
+		}
+		n, err := p.parseUnary()
+		if err != nil || n == nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case tokOpen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.peek(); c == nil || c.kind != tokClose {
+			return nil, errors.New("missing closing bracket")
+		}
+		p.pos++
+		return n, nil
+	case tokPhrase:
+		return termsNode(tokenize(t.text)), nil
+	case tokRegex:
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex: %v", err)
+		}
+		return regexNode{re}, nil
+	case tokClose:
+		return nil, errors.New("unexpected )")
+	}
+	return parseWord(t.text)
+}
+
+// termsNode matches a single term on its own or a run of them as a phrase
+func termsNode(terms []string) queryNode {
+	switch len(terms) {
+	case 0:
+		return nil
+	case 1:
+		return termNode(terms[0])
+	}
+	return phraseNode(terms)
+}
+
+// parseWord handles a plain word or field:value filter.  Anything that
+// isn't a known field, such as a URL, is searched for as text.
+func parseWord(w string) (queryNode, error) {
+	field, value, found := strings.Cut(w, ":")
+	if !found {
+		return termsNode(tokenize(w)), nil
+	}
+	switch strings.ToLower(field) {
+	case "tag":
+		return tagNode(value), nil
+	case "path":
+		return pathNode(value), nil
+	case "title":
+		return titleNode(value), nil
+	case "is":
+		value = strings.ToLower(value)
+		if value != "published" && value != "encrypted" {
+			return nil, fmt.Errorf("unknown is:%v, expected is:published or is:encrypted", value)
+		}
+		return isNode(value), nil
+	case "modified":
+		op := ""
+		for _, o := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(value, o) {
+				op = o
+				break
+			}
+		}
+		date, err := time.ParseInLocation("2006-01-02", strings.TrimPrefix(value, op), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("bad date %q, expected e.g. modified:>2026-01-31", value)
+		}
+		return modifiedNode{op: op, date: date}, nil
+	}
+	return termsNode(tokenize(w)), nil
+}
+
+// collect gathers the terms and patterns outside of any NOT
+func (q *query) collect(n queryNode) {
+	switch n := n.(type) {
+	case andNode:
+		for _, c := range n {
+			q.collect(c)
+		}
+	case orNode:
+		for _, c := range n {
+			q.collect(c)
+		}
+	case termNode:
+		q.terms = append(q.terms, string(n))
+	case phraseNode:
+		q.terms = append(q.terms, n...)
+	case regexNode:
+		q.patterns = append(q.patterns, n.re)
+	}
+}
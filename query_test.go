@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, q := range []string{
+		`"unclosed`,
+		`/unclosed`,
+		`/[/`,
+		`(a OR b`,
+		`a )`,
+		`modified:>yesterday`,
+		`is:draft`,
+		`a NOT`,
+		`!!!`,
+		``,
+	} {
+		if _, err := parseQuery(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+}
+
+func TestParseQueryTerms(t *testing.T) {
+	q, err := parseQuery(`apples "green pears" -bananas NOT (cherry OR plums) /ki+wi/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"apple", "green", "pear"}
+	if !reflect.DeepEqual(q.terms, expected) {
+		t.Errorf("Expected terms %v but got %v", expected, q.terms)
+	}
+	if len(q.patterns) != 1 || q.patterns[0].String() != "ki+wi" {
+		t.Errorf("Expected the regex to be collected but got %v", q.patterns)
+	}
+}
+
+func queryTestIndex() (*searchIndex, stubStorage) {
+	bodies := map[string]string{
+		"Recipes/apple pie":    "Take some green apples and bake them",
+		"Recipes/pear tart":    "Pears are green too, apples are not needed",
+		"Projects/wiki search": "Search should rank apples above pears\nsee issue #123",
+		"Projects/secret":      "The code is 4321",
+	}
+	idx := newSearchIndex("")
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.Local) }
+	idx.add(indexedDoc{Title: "Recipes/apple pie", Tags: []string{"Food"}, Changed: day(1), Published: true}, bodies["Recipes/apple pie"])
+	idx.add(indexedDoc{Title: "Recipes/pear tart", Tags: []string{"food", "baking"}, Changed: day(10)}, bodies["Recipes/pear tart"])
+	idx.add(indexedDoc{Title: "Projects/wiki search", Changed: day(20)}, bodies["Projects/wiki search"])
+	idx.add(indexedDoc{Title: "Projects/secret", Changed: day(20), Encrypted: true}, bodies["Projects/secret"])
+
+	s := stubStorage{
+		getPageFunc: func(p *wikiPage) (*wikiPage, error) {
+			body, ok := bodies[p.Title]
+			if !ok {
+				return p, errors.New("not found")
+			}
+			p.Body = template.HTML(body)
+			return p, nil
+		},
+	}
+	return idx, s
+}
+
+func TestQueryMatch(t *testing.T) {
+	idx, s := queryTestIndex()
+	for q, expected := range map[string][]string{
+		`apples`:                       {"Projects/wiki search", "Recipes/apple pie", "Recipes/pear tart"},
+		`apples pears`:                 {"Projects/wiki search", "Recipes/pear tart"},
+		`apples AND pears`:             {"Projects/wiki search", "Recipes/pear tart"},
+		`pie OR tart`:                  {"Recipes/apple pie", "Recipes/pear tart"},
+		`apples -pears`:                {"Recipes/apple pie"},
+		`apples NOT (pie OR search)`:   {"Recipes/pear tart"},
+		`"green apples"`:               {"Recipes/apple pie"},
+		`"apples green"`:               {},
+		`"wiki search"`:                {"Projects/wiki search"},
+		`tag:food`:                     {"Recipes/apple pie", "Recipes/pear tart"},
+		`tag:baking OR is:published`:   {"Recipes/apple pie", "Recipes/pear tart"},
+		`path:projects/`:               {"Projects/secret", "Projects/wiki search"},
+		`title:"pie"`:                  {"Recipes/apple pie"},
+		`is:encrypted`:                 {"Projects/secret"},
+		`modified:>2026-01-10`:         {"Projects/secret", "Projects/wiki search"},
+		`modified:>=2026-01-10 apples`: {"Projects/wiki search", "Recipes/pear tart"},
+		`modified:<2026-01-10`:         {"Recipes/apple pie"},
+		`modified:2026-01-10`:          {"Recipes/pear tart"},
+		`/#\d+/`:                       {"Projects/wiki search"},
+		`/\d{4}/ path:Projects/`:       {"Projects/secret"},
+		`http://example.com`:           {},
+	} {
+		parsed, err := parseQuery(q)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", q, err)
+			continue
+		}
+		titles := []string{}
+		for _, h := range idx.match(&s, parsed) {
+			titles = append(titles, h.Title)
+		}
+		sort.Strings(titles)
+		if !reflect.DeepEqual(titles, expected) {
+			t.Errorf("Expected %q to match %v but got %v", q, expected, titles)
+		}
+	}
+}
+
+func TestQueryRegexSnippet(t *testing.T) {
+	idx, s := queryTestIndex()
+	res, _, err := idx.query(&s, `/#\d+/`, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].LineNum != "2" || res[0].Text != "see issue #123" {
+		t.Errorf("Expected the regex match as the snippet but got %+v", res)
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	q, _ := parseQuery(`apples "green pear" /#\d+/ -bananas`)
+	text := "Green apples, bananas and pears (see #12)"
+	spans := highlights(text, q)
+	marked := []string{}
+	for _, h := range spans {
+		marked = append(marked, text[h[0]:h[1]])
+	}
+	expected := []string{"Green", "apples", "pears", "#12"}
+	if !reflect.DeepEqual(marked, expected) {
+		t.Errorf("Expected %v highlighted but got %v", expected, marked)
+	}
+
+	qr := QueryResults{Text: "a <b> apple", Highlights: highlights("a <b> apple", q)}
+	if qr.Marked() != "a &lt;b&gt; <mark>apple</mark>" {
+		t.Errorf("Unexpected marked text: %v", qr.Marked())
+	}
+}
+
+func TestSearchHandlerBadQuery(t *testing.T) {
+	s := stubStorage{queryPagesFunc: parseQueryResults}
+	req := httptest.NewRequest("GET", `http://localhost/wiki/search/?term=%22oops`, nil)
+	w := httptest.NewRecorder()
+
+	makeSearchHandler(stubNavFunc, &s)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Failed to get a 200 response, got %v", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "missing closing quote") {
+		t.Errorf("Expected the query error on the page: %v", w.Body.String())
+	}
+}
+
+// parseQueryResults stands in for a storage that only checks the query
+func parseQueryResults(q string) ([]QueryResults, error) {
+	if _, err := parseQuery(q); err != nil {
+		return nil, err
+	}
+	return []QueryResults{{WikiName: "found", LineNum: "1", Text: q}}, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// brokenLink is a link on a page that goes nowhere
+type brokenLink struct {
+	Page    string
+	Link    string
+	Problem string
+}
+
+// linkReport is the result of checking every link in the wiki
+type linkReport struct {
+	Pages        int
+	Broken       []brokenLink
+	Orphans      []string
+	UnusedImages []string
+}
+
+type linkReportPage struct {
+	basePage
+	linkReport
+}
+
+var markdownLink = regexp.MustCompile(`\]\(\s*<?(/wiki/view/[^)\s>]*)`)
+var rawLink = regexp.MustCompile(`/wiki/raw/([^)\s"'<>?#]+)`)
+
+// pageLink is a link from one page to another, possibly to a heading
+type pageLink struct {
+	Target  string
+	Heading string
+	Text    string
+}
+
+// pageLinks finds the {{wiki links}} and markdown links to other pages in a
+// page body
+func pageLinks(body string) []pageLink {
+	links := []pageLink{}
+	for _, m := range wikiWordRe.FindAllStringSubmatch(body, -1) {
+		links = append(links, pageLink{Target: strings.TrimSpace(m[1]), Heading: m[2], Text: m[0]})
+	}
+	for _, m := range markdownLink.FindAllStringSubmatch(body, -1) {
+		target, fragment, _ := strings.Cut(strings.TrimPrefix(m[1], "/wiki/view/"), "#")
+		if t, err := url.PathUnescape(target); err == nil {
+			target = t
+		}
+		if f, err := url.PathUnescape(fragment); err == nil {
+			fragment = f
+		}
+		links = append(links, pageLink{Target: target, Heading: fragment, Text: m[1]})
+	}
+	return links
+}
+
+// buildLinkReport reads every page listed in the nav index and checks its
+// links to other pages, headings and raw files
+func buildLinkReport(s storage) linkReport {
+	report := linkReport{Broken: []brokenLink{}, Orphans: []string{}, UnusedImages: []string{}}
+
+	lr := newLinkResolver(s)
+	var titles []string
+	for _, n := range flattenWikis(s.IndexWikiFiles("", wikiDir)) {
+		if strings.HasSuffix(n.file, ".md") {
+			titles = append(titles, strings.TrimPrefix(n.URL, "/"))
+		}
+	}
+	sort.Strings(titles)
+
+	bodies := map[string]string{}
+	for _, title := range titles {
+		p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+		if err != nil {
+			log.Printf("Link report couldn't read %v: %v", title, err)
+			continue
+		}
+		bodies[title] = string(p.Body)
+		lr.headings[title] = pageHeadings(bodies[title])
+	}
+	report.Pages = len(bodies)
+
+	linked := map[string]bool{}
+	referenced := map[string]bool{}
+	for _, title := range titles {
+		body, ok := bodies[title]
+		if !ok {
+			continue
+		}
+		for _, l := range pageLinks(body) {
+			// Placeholders in templates look like links but aren't
+			if isPlaceholder(title, l.Text) {
+				continue
+			}
+			target := lr.resolve(l.Target)
+			if target == "" {
+				report.Broken = append(report.Broken, brokenLink{Page: title, Link: l.Text, Problem: "missing page"})
+				continue
+			}
+			if target != title {
+				linked[target] = true
+			}
+			if l.Heading != "" && !lr.hasHeading(target, l.Heading) {
+				report.Broken = append(report.Broken, brokenLink{Page: title, Link: l.Text, Problem: "missing heading"})
+			}
+		}
+		for _, m := range rawLink.FindAllStringSubmatch(body, -1) {
+			path := m[1]
+			if p, err := url.PathUnescape(path); err == nil {
+				path = p
+			}
+			referenced[path] = true
+			if _, err := os.Stat(wikiDir + path); err != nil {
+				report.Broken = append(report.Broken, brokenLink{Page: title, Link: "/wiki/raw/" + m[1], Problem: "missing file"})
+			}
+		}
+	}
+
+	for _, title := range titles {
+		if !linked[title] {
+			report.Orphans = append(report.Orphans, title)
+		}
+	}
+
+	filepath.WalkDir(wikiDir+"images", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel := strings.TrimPrefix(path, wikiDir)
+		if !referenced[rel] {
+			report.UnusedImages = append(report.UnusedImages, rel)
+		}
+		return nil
+	})
+	return report
+}
+
+func reportHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
+	if p.Title != "links" {
+		http.NotFound(w, r)
+		return
+	}
+	rp := linkReportPage{basePage: basePage{Title: "Link report", Nav: p.Nav}, linkReport: buildLinkReport(s)}
+	renderTemplate(w, "links", rp)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPageHeadings(t *testing.T) {
+	body := "# Intro\ntext\n## Intro\n```\n# not a heading\n```\nSetext one\n==========\nSetext two\n---\n- list\n---\n### Custom {#my-id} ###\n#nospace"
+	expected := []heading{
+		{1, "Intro", "intro", 0},
+		{2, "Intro", "intro-1", 2},
+		{1, "Setext one", "setext-one", 6},
+		{2, "Setext two", "setext-two", 8},
+		{3, "Custom", "my-id", 12},
+	}
+	if hs := pageHeadings(body); !reflect.DeepEqual(hs, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, hs)
+	}
+
+	hs := pageHeadings("## Some Heading")
+	for _, f := range []string{"some-heading", "Some Heading", "some heading"} {
+		if !hasHeading(hs, f) {
+			t.Errorf("Expected %q to match", f)
+		}
+	}
+	if hasHeading(hs, "other") {
+		t.Error("Didn't expect other to match")
+	}
+}
+
+func TestLinkReport(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	for title, body := range map[string]string{
+		"home":          "{{folder/target#Section}} {{folder/target#Nope}} {{missing}} {{nickname}}\n[md](/wiki/view/folder/target#section) [bad](/wiki/view/gone)",
+		"folder/target": "## Section\n![img](/wiki/raw/images/folder/target/used.png) ![gone](/wiki/raw/images/nothere.png) {{home}}",
+		"lonely":        "{{lonely}} only links to itself",
+		"named":         "reached by alias",
+	} {
+		if err := (&wikiPage{basePage: basePage{Title: title}, Body: template.HTML(body)}).save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setAliases(&fs, "named", []string{"nickname"})
+	os.MkdirAll(getWikiImagesDir("folder/target"), 0755)
+	os.WriteFile(getWikiImagesDir("folder/target")+"/used.png", []byte("png"), 0644)
+	os.WriteFile(getWikiImagesDir("folder/target")+"/unused.png", []byte("png"), 0644)
+
+	report := buildLinkReport(&fs)
+
+	if report.Pages != 4 {
+		t.Errorf("Expected 4 pages checked but got %v", report.Pages)
+	}
+	expected := []brokenLink{
+		{"folder/target", "/wiki/raw/images/nothere.png", "missing file"},
+		{"home", "{{folder/target#Nope}}", "missing heading"},
+		{"home", "{{missing}}", "missing page"},
+		{"home", "/wiki/view/gone", "missing page"},
+	}
+	if !reflect.DeepEqual(report.Broken, expected) {
+		t.Errorf("Expected broken links %+v but got %+v", expected, report.Broken)
+	}
+	if !reflect.DeepEqual(report.Orphans, []string{"lonely"}) {
+		t.Errorf("Expected lonely to be the only orphan but got %v", report.Orphans)
+	}
+	if !reflect.DeepEqual(report.UnusedImages, []string{"images/folder/target/unused.png"}) {
+		t.Errorf("Expected one unused image but got %v", report.UnusedImages)
+	}
+
+	w := httptest.NewRecorder()
+	reportHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/report/links", nil), &wikiPage{basePage: basePage{Title: "links"}}, &fs)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "missing heading") {
+		t.Errorf("Expected the report page but got %v: %v", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	reportHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/report/other", nil), &wikiPage{basePage: basePage{Title: "other"}}, &fs)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 for an unknown report, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	innerAPIHandler(w, httptest.NewRequest("GET", "http://localhost/api?report=links", nil), &fs)
+	var fromAPI linkReport
+	if err := json.Unmarshal(w.Body.Bytes(), &fromAPI); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromAPI, report) {
+		t.Errorf("Expected the API to return the same report but got %+v", fromAPI)
+	}
+}
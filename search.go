@@ -1,12 +1,50 @@
 package main
 
-import "strings"
+import (
+	"html/template"
+	"strings"
+)
 
 // QueryResults is used to hold search results after a wiki search
 type QueryResults struct {
 	WikiName string
 	LineNum  string
 	Text     string
+	Score    float64
+	Summary  string
+	// Highlights are the start and end byte offsets of each match in Text
+	Highlights [][2]int
+}
+
+// resultWindow is the part of a list of results starting at offset and at
+// most limit long, or the rest of the list when limit is 0
+func resultWindow[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	end := len(items)
+	if limit > 0 {
+		end = min(offset+limit, end)
+	}
+	return items[offset:end]
+}
+
+// Marked is the text with each highlight wrapped in a mark tag
+func (qr QueryResults) Marked() template.HTML {
+	var sb strings.Builder
+	last := 0
+	for _, h := range qr.Highlights {
+		if h[0] < last || h[1] > len(qr.Text) {
+			break
+		}
+		sb.WriteString(template.HTMLEscapeString(qr.Text[last:h[0]]))
+		sb.WriteString("<mark>")
+		sb.WriteString(template.HTMLEscapeString(qr.Text[h[0]:h[1]]))
+		sb.WriteString("</mark>")
+		last = h[1]
+	}
+	sb.WriteString(template.HTMLEscapeString(qr.Text[last:]))
+	return template.HTML(sb.String())
 }
 
 // ParseQueryResults converts a result string to a query result
@@ -15,7 +53,7 @@ func ParseQueryResults(source []string) []QueryResults {
 	for _, r := range source {
 		sub := strings.Split(r, "\t")
 		if len(sub) < 2 {
-			res = append(res, QueryResults{"ERROR", "", "Invalid query result"})
+			res = append(res, QueryResults{WikiName: "ERROR", Text: "Invalid query result"})
 			continue
 		}
 		res = append(res, QueryResults{
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"encoding/gob"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// BM25 tuning, the usual defaults
+const (
+	bm25K1     = 1.2
+	bm25B      = 0.75
+	titleBoost = 2.0
+	snippetLen = 160
+)
+
+// searchIndexVersion is bumped whenever what gets indexed changes so saved
+// indexes from older versions are rebuilt rather than trusted
+const searchIndexVersion = 4
+
+// indexedDoc is what the search index knows about a page
+type indexedDoc struct {
+	Title string
+	// Modified and Size are the file's, to spot when it needs re-reading
+	Modified time.Time
+	Size     int64
+	// Changed is when the page was last modified as far as the wiki knows
+	Changed    time.Time
+	Length     int
+	TitleTerms []string
+	// Terms are the distinct terms in the body, to find its postings
+	Terms     []string
+	Tags      []string
+	Published bool
+	Encrypted bool
+	// Links are the pages this one links to
+	Links []string
+}
+
+// searchIndex is an inverted index of the terms in every wiki page.  It is
+// kept in memory and saved to disk so it doesn't need building from scratch
+// at startup.  Encrypted pages are only ever held in memory.  It also
+// tracks the links between pages, in both directions.
+type searchIndex struct {
+	mu       sync.RWMutex
+	saveMu   sync.Mutex
+	file     string
+	pending  *time.Timer
+	linkedBy map[string]map[string]bool
+	Version  int
+	Docs     map[string]*indexedDoc
+	Postings map[string]map[string][]int
+	TotalLen int
+}
+
+// searchHit is a page that matched a search along with its relevance
+type searchHit struct {
+	Title string
+	Score float64
+}
+
+func newSearchIndex(file string) *searchIndex {
+	return &searchIndex{
+		file:     file,
+		linkedBy: map[string]map[string]bool{},
+		Docs:     map[string]*indexedDoc{},
+		Postings: map[string]map[string][]int{},
+	}
+}
+
+// stem strips common English suffixes so that e.g. "jumps", "jumped" and
+// "jumping" all index under "jump".  It is a cut down Porter stemmer
+// that only covers plurals, -ed, -ing and -ly.
+func stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ies"):
+		word = strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+	case strings.HasSuffix(word, "s"):
+		word = strings.TrimSuffix(word, "s")
+	}
+	for _, suffix := range []string{"ing", "ed", "ly"} {
+		base := strings.TrimSuffix(word, suffix)
+		if base != word && len(base) >= 3 && strings.ContainsAny(base, "aeiouy") {
+			// hopping -> hop
+			if n := len(base); n > 3 && base[n-1] == base[n-2] && !strings.ContainsRune("lsz", rune(base[n-1])) {
+				base = base[:n-1]
+			}
+			return base
+		}
+	}
+	return word
+}
+
+// tokenize splits text into lower case stemmed terms
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, stem(strings.ToLower(w)))
+	}
+	return terms
+}
+
+// add indexes a page, replacing anything already held for it
+func (idx *searchIndex) add(doc indexedDoc, body string) {
+	terms := tokenize(body)
+	doc.Length = len(terms)
+	doc.TitleTerms = tokenize(doc.Title)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(doc.Title)
+
+	for pos, term := range terms {
+		docs, ok := idx.Postings[term]
+		if !ok {
+			docs = map[string][]int{}
+			idx.Postings[term] = docs
+		}
+		if _, ok := docs[doc.Title]; !ok {
+			doc.Terms = append(doc.Terms, term)
+		}
+		docs[doc.Title] = append(docs[doc.Title], pos)
+	}
+	idx.Docs[doc.Title] = &doc
+	idx.TotalLen += doc.Length
+	idx.addLinks(&doc)
+}
+
+func (idx *searchIndex) addLinks(doc *indexedDoc) {
+	for _, link := range doc.Links {
+		if idx.linkedBy[link] == nil {
+			idx.linkedBy[link] = map[string]bool{}
+		}
+		idx.linkedBy[link][doc.Title] = true
+	}
+}
+
+func (idx *searchIndex) remove(title string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(title)
+}
+
+func (idx *searchIndex) removeLocked(title string) {
+	doc, ok := idx.Docs[title]
+	if !ok {
+		return
+	}
+	for _, term := range doc.Terms {
+		docs := idx.Postings[term]
+		delete(docs, title)
+		if len(docs) == 0 {
+			delete(idx.Postings, term)
+		}
+	}
+	for _, link := range doc.Links {
+		delete(idx.linkedBy[link], title)
+		if len(idx.linkedBy[link]) == 0 {
+			delete(idx.linkedBy, link)
+		}
+	}
+	idx.TotalLen -= doc.Length
+	delete(idx.Docs, title)
+}
+
+// links lists the pages a page links to
+func (idx *searchIndex) links(title string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	links := []string{}
+	if doc, ok := idx.Docs[title]; ok {
+		links = append(links, doc.Links...)
+	}
+	return links
+}
+
+// backlinks lists the other pages that link to a page, in title order
+func (idx *searchIndex) backlinks(title string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	titles := []string{}
+	for from := range idx.linkedBy[title] {
+		if from != title {
+			titles = append(titles, from)
+		}
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// idf is the BM25 inverse document frequency of a term
+func (idx *searchIndex) idf(term string) float64 {
+	n := float64(len(idx.Docs))
+	df := float64(len(idx.Postings[term]))
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+// score gives the BM25 relevance of a page for the terms, with a boost for
+// terms that appear in the title
+func (idx *searchIndex) score(title string, terms []string) float64 {
+	doc := idx.Docs[title]
+	avg := float64(idx.TotalLen) / math.Max(float64(len(idx.Docs)), 1)
+	score := 0.0
+	for _, term := range terms {
+		tf := float64(len(idx.Postings[term][title]))
+		if tf > 0 {
+			norm := 1 - bm25B + bm25B*float64(doc.Length)/math.Max(avg, 1)
+			score += idx.idf(term) * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+		if contains(term, doc.TitleTerms) {
+			score += idx.idf(term) * titleBoost
+		}
+	}
+	return score
+}
+
+// match finds the pages a query matches, most relevant first.  Pages are
+// checked one by one, which is plenty fast for a personal wiki and lets
+// filters and regexes mix freely with words.
+func (idx *searchIndex) match(s storage, q *query) []searchHit {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	hits := []searchHit{}
+	for title, doc := range idx.Docs {
+		if q.root.match(&queryContext{idx: idx, s: s, doc: doc}) {
+			hits = append(hits, searchHit{Title: title, Score: idx.score(title, q.terms)})
+		}
+	}
+	sortHits(hits)
+	return hits
+}
+
+// sortHits orders by score, falling back to title so results are stable
+func sortHits(hits []searchHit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Title < hits[j].Title
+	})
+}
+
+// snippet picks the first line of the body that contains one of the terms
+// or patterns in the query and trims it down to a readable length around
+// the match
+func snippet(body string, q *query) (int, string) {
+	lines := splitLines(body)
+	for i, line := range lines {
+		for _, t := range tokenize(line) {
+			if contains(t, q.terms) {
+				return i + 1, trimSnippet(line, t)
+			}
+		}
+		for _, re := range q.patterns {
+			if loc := re.FindStringIndex(line); loc != nil {
+				return i + 1, trimSnippet(line, strings.ToLower(line[loc[0]:loc[1]]))
+			}
+		}
+	}
+	if len(lines) > 0 {
+		return 1, trimSnippet(lines[0], "")
+	}
+	return 0, ""
+}
+
+func trimSnippet(line, term string) string {
+	line = strings.TrimSpace(line)
+	if len(line) <= snippetLen {
+		return line
+	}
+	start := strings.Index(strings.ToLower(line), term) - snippetLen/4
+	if start < 0 || term == "" {
+		start = 0
+	}
+	end := start + snippetLen
+	if end > len(line) {
+		end = len(line)
+		start = end - snippetLen
+	}
+	// Don't cut a multi byte character in half
+	for start > 0 && !isRuneStart(line[start]) {
+		start--
+	}
+	for end < len(line) && !isRuneStart(line[end]) {
+		end++
+	}
+	trimmed := line[start:end]
+	if start > 0 {
+		trimmed = "..." + trimmed
+	}
+	if end < len(line) {
+		trimmed += "..."
+	}
+	return trimmed
+}
+
+// highlights finds the byte offsets of the words and patterns from the
+// query within a snippet, in order and without overlaps
+func highlights(text string, q *query) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range text + " " {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			if contains(stem(strings.ToLower(text[start:i])), q.terms) {
+				spans = append(spans, [2]int{start, i})
+			}
+			start = -1
+		}
+	}
+	for _, re := range q.patterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if loc[1] > loc[0] {
+				spans = append(spans, [2]int{loc[0], loc[1]})
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	merged := [][2]int{}
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s[0] <= merged[n-1][1] {
+			if s[1] > merged[n-1][1] {
+				merged[n-1][1] = s[1]
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// load reads a saved index from disk.  A missing or unreadable file just
+// leaves the index empty to be filled by refresh.
+func (idx *searchIndex) load() {
+	if idx.file == "" {
+		return
+	}
+	f, err := os.Open(idx.file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var saved searchIndex
+	if err := gob.NewDecoder(f).Decode(&saved); err != nil {
+		log.Printf("[search] ignoring saved index: %v", err)
+		return
+	}
+	if saved.Version != searchIndexVersion || saved.Docs == nil || saved.Postings == nil {
+		log.Printf("[search] ignoring saved index from version %v", saved.Version)
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.Docs = saved.Docs
+	idx.Postings = saved.Postings
+	idx.TotalLen = saved.TotalLen
+	idx.linkedBy = map[string]map[string]bool{}
+	for _, doc := range idx.Docs {
+		idx.addLinks(doc)
+	}
+}
+
+// save writes the index to disk leaving out encrypted pages
+func (idx *searchIndex) save() {
+	if idx.file == "" {
+		return
+	}
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+
+	idx.mu.RLock()
+	out := searchIndex{Version: searchIndexVersion, Docs: map[string]*indexedDoc{}, Postings: map[string]map[string][]int{}}
+	for title, doc := range idx.Docs {
+		if !doc.Encrypted {
+			out.Docs[title] = doc
+			out.TotalLen += doc.Length
+		}
+	}
+	for term, docs := range idx.Postings {
+		for title, positions := range docs {
+			if _, ok := out.Docs[title]; ok {
+				if out.Postings[term] == nil {
+					out.Postings[term] = map[string][]int{}
+				}
+				out.Postings[term][title] = positions
+			}
+		}
+	}
+	idx.mu.RUnlock()
+
+	if err := createDir(idx.file); err != nil {
+		log.Printf("[search] %v", err)
+		return
+	}
+	tmp := idx.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Printf("[search] %v", err)
+		return
+	}
+	if err := gob.NewEncoder(f).Encode(&out); err != nil {
+		f.Close()
+		log.Printf("[search] %v", err)
+		return
+	}
+	f.Close()
+	if err := os.Rename(tmp, idx.file); err != nil {
+		log.Printf("[search] %v", err)
+	}
+}
+
+// saveLater batches up saves so a burst of page writes only rewrites the
+// index file once
+func (idx *searchIndex) saveLater() {
+	if idx.file == "" {
+		return
+	}
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+	if idx.pending == nil {
+		idx.pending = time.AfterFunc(5*time.Second, func() {
+			idx.saveMu.Lock()
+			idx.pending = nil
+			idx.saveMu.Unlock()
+			idx.save()
+		})
+	}
+}
+
+// indexPage (re)reads a page through the storage and adds it to the index
+func (idx *searchIndex) indexPage(s storage, title string, info os.FileInfo) {
+	p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+	if err != nil {
+		idx.remove(title)
+		return
+	}
+	doc := indexedDoc{
+		Title:     title,
+		Tags:      GetTagsFromString(p.Tags),
+		Published: p.Published,
+		Encrypted: p.Encrypted,
+		Links:     parseLinks(title, string(p.Body)),
+	}
+	if info != nil {
+		doc.Modified = info.ModTime()
+		doc.Size = info.Size()
+	}
+	doc.Changed, _ = time.Parse(time.RFC3339, p.Modified)
+	idx.add(doc, string(p.Body))
+}
+
+// listPages finds every markdown page under root, skipping the folders the
+// wiki keeps its own data in
+func listPages(root string) map[string]os.FileInfo {
+	pages := map[string]os.FileInfo{}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != root && (strings.HasPrefix(d.Name(), ".") || (d.IsDir() && contains(d.Name(), specialDir))) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".md") {
+			if info, err := d.Info(); err == nil {
+				pages[strings.TrimSuffix(strings.TrimPrefix(path, root), ".md")] = info
+			}
+		}
+		return nil
+	})
+	return pages
+}
+
+// refresh brings the index in line with the pages on disk, only re-reading
+// pages that are new or have changed since they were indexed.  Encrypted
+// pages are never in the saved index so get re-read every time.
+func (idx *searchIndex) refresh(s storage, root string) {
+	start := time.Now()
+	pages := listPages(root)
+
+	idx.mu.RLock()
+	var stale []string
+	for title := range idx.Docs {
+		if _, ok := pages[title]; !ok {
+			stale = append(stale, title)
+		}
+	}
+	var changed []string
+	for title, info := range pages {
+		doc, ok := idx.Docs[title]
+		if !ok || doc.Encrypted || !doc.Modified.Equal(info.ModTime()) || doc.Size != info.Size() {
+			changed = append(changed, title)
+		}
+	}
+	idx.mu.RUnlock()
+
+	for _, title := range stale {
+		idx.remove(title)
+	}
+	for _, title := range changed {
+		idx.indexPage(s, title, pages[title])
+	}
+	log.Printf("[search] index refreshed, %v changed, %v removed in %v", len(changed), len(stale), time.Since(start))
+	if len(changed) > 0 || len(stale) > 0 {
+		idx.save()
+	}
+}
+
+// query parses and runs a search typed by the user
+func (idx *searchIndex) query(s storage, text string, offset, limit int) ([]QueryResults, int, error) {
+	q, err := parseQuery(text)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Pages are only read for the snippets of the hits being returned
+	hits := idx.match(s, q)
+	return idx.results(s, resultWindow(hits, offset, limit), q), len(hits), nil
+}
+
+// results turns hits into query results with a snippet from each page.
+// Encrypted pages get the same placeholder as their summary rather than
+// a snippet.
+func (idx *searchIndex) results(s storage, hits []searchHit, q *query) []QueryResults {
+	res := make([]QueryResults, 0, len(hits))
+	for _, h := range hits {
+		qr := QueryResults{WikiName: h.Title, Score: h.Score}
+		if p, err := s.getPage(&wikiPage{basePage: basePage{Title: h.Title}}); err == nil && p.Encrypted {
+			qr.Text = encryptedSummary
+			qr.Summary = encryptedSummary
+		} else if err == nil {
+			line, text := snippet(string(p.Body), q)
+			qr.LineNum = strconv.Itoa(line)
+			qr.Text = text
+			qr.Highlights = highlights(text, q)
+			qr.Summary = pageSummary(p)
+		}
+		res = append(res, qr)
+	}
+	return res
+}
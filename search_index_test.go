@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	terms := tokenize("Jumps, JUMPED and jumping! Hopping apples/ponies 42")
+	expected := "jump jump and jump hop apple pony 42"
+	if strings.Join(terms, " ") != expected {
+		t.Errorf("Expected %q but got %q", expected, strings.Join(terms, " "))
+	}
+}
+
+func TestSearchIndexRanking(t *testing.T) {
+	idx := newSearchIndex("")
+	idx.add(indexedDoc{Title: "once"}, "a page that mentions apples just the once among lots of other words")
+	idx.add(indexedDoc{Title: "often"}, "apples apples apples")
+	idx.add(indexedDoc{Title: "Apple pie"}, "a recipe")
+	idx.add(indexedDoc{Title: "none"}, "oranges")
+
+	q, _ := parseQuery("apple")
+	hits := idx.match(nil, q)
+	if len(hits) != 3 {
+		t.Fatalf("Expected 3 hits but got %v", hits)
+	}
+	if hits[0].Title != "Apple pie" || hits[1].Title != "often" || hits[2].Title != "once" {
+		t.Errorf("Hits in the wrong order: %v", hits)
+	}
+
+	idx.remove("often")
+	if hits := idx.match(nil, q); len(hits) != 2 {
+		t.Errorf("Expected 2 hits after removing a page but got %v", hits)
+	}
+	if _, ok := idx.Postings["apple"]["often"]; ok {
+		t.Error("Removed page still has postings")
+	}
+}
+
+// TestSearchIndexPaging checks only the pages being returned are read for
+// their snippets
+func TestSearchIndexPaging(t *testing.T) {
+	idx := newSearchIndex("")
+	idx.add(indexedDoc{Title: "often"}, "apples apples apples")
+	idx.add(indexedDoc{Title: "twice"}, "apples and apples")
+	idx.add(indexedDoc{Title: "once"}, "a page that mentions apples just the once among lots of other words")
+
+	var read []string
+	s := stubStorage{getPageFunc: func(p *wikiPage) (*wikiPage, error) {
+		read = append(read, p.Title)
+		p.Body = "apples"
+		return p, nil
+	}}
+	res, total, err := idx.query(&s, "apples", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(res) != 1 || res[0].WikiName != "twice" {
+		t.Errorf("Expected the second of 3 hits but got %v of %+v", total, res)
+	}
+	if len(read) != 1 || read[0] != "twice" {
+		t.Errorf("Expected only the returned page to be read but read %v", read)
+	}
+
+	if res, total, _ := idx.query(&s, "apples", 5, 1); total != 3 || res == nil || len(res) != 0 {
+		t.Errorf("Expected an empty page past the end but got %v of %+v", total, res)
+	}
+}
+
+func mustQuery(t *testing.T, s storage, text string) []QueryResults {
+	res, _, err := s.queryPages(text, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTrimSnippet(t *testing.T) {
+	line := strings.Repeat("x ", 100) + "needle" + strings.Repeat(" y", 100)
+	s := trimSnippet(line, "needle")
+	if !strings.Contains(s, "needle") || !strings.HasPrefix(s, "...") || !strings.HasSuffix(s, "...") {
+		t.Errorf("Snippet doesn't centre on the match: %q", s)
+	}
+	if len(s) > snippetLen+6 {
+		t.Errorf("Snippet too long: %v", len(s))
+	}
+}
+
+func TestCachedStorageSearch(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	originalWikiDir := wikiDir
+	originalTagDir := tagDir
+	originalPubDir := pubDir
+	originalHistDir := histDir
+	originalEkey := ekey
+	originalSpecialDir := specialDir
+	wikiDir = tmpDir + "/"
+	tagDir = wikiDir + "tags/"
+	pubDir = wikiDir + "pub/"
+	histDir = wikiDir + "history/"
+	ekey = []byte("12345678901234567890123456789012")
+	specialDir = []string{"tags", "pub", "history", "aliases", "trash", "meta", "drafts"}
+	defer func() {
+		wikiDir = originalWikiDir
+		tagDir = originalTagDir
+		pubDir = originalPubDir
+		histDir = originalHistDir
+		ekey = originalEkey
+		specialDir = originalSpecialDir
+	}()
+	os.MkdirAll(tagDir, 0755)
+	os.WriteFile(wikiDir+"existing.md", []byte("first line\nthe zebra was here"), 0600)
+
+	indexFile := filepath.Join(tmpDir, ".index", "search.gob")
+	cached := newCachedStorage(&fileStorage{TagDir: tagDir}, wikiDir, tagDir)
+	cached.enableSearch(indexFile)
+
+	res := mustQuery(t, &cached, "zebras")
+	if len(res) != 1 || res[0].WikiName != "existing" || res[0].LineNum != "2" || res[0].Text != "the zebra was here" {
+		t.Errorf("Expected a hit on line 2 of existing but got %+v", res)
+	}
+
+	secret := wikiPage{basePage: basePage{Title: "folder/secret"}, Body: "the secret zebra password", Encrypted: true}
+	if err := secret.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+	plain := wikiPage{basePage: basePage{Title: "plain"}, Body: "an unencrypted giraffe", Tags: "animals"}
+	if err := plain.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+
+	res = mustQuery(t, &cached, "zebra")
+	if len(res) != 2 {
+		t.Errorf("Expected the encrypted page to be searchable but got %+v", res)
+	}
+	for _, r := range res {
+		if r.WikiName == "folder/secret" && (r.Text != encryptedSummary || r.LineNum != "" || len(r.Highlights) != 0) {
+			t.Errorf("Expected a placeholder rather than a snippet from the encrypted page but got %+v", r)
+		}
+	}
+	if res := mustQuery(t, &cached, "giraffe"); len(res) != 1 || res[0].Score <= 0 {
+		t.Errorf("Expected a scored hit for giraffe but got %+v", res)
+	}
+	if doc := cached.search.Docs["plain"]; doc == nil || len(doc.Tags) != 1 || doc.Tags[0] != "animals" {
+		t.Errorf("Expected tags to be indexed but got %+v", doc)
+	}
+
+	// Re-saving a page drops the postings of terms it no longer has
+	plain.Body = "an unencrypted okapi"
+	if err := plain.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cached.search.Postings["giraffe"]; ok {
+		t.Errorf("Expected giraffe to be gone from the index but got %v", cached.search.Postings["giraffe"])
+	}
+	plain.Body = "an unencrypted giraffe"
+	if err := plain.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+
+	cached.search.save()
+	saved, err := os.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(saved, []byte("password")) || bytes.Contains(saved, []byte("folder/secret")) {
+		t.Error("Encrypted page leaked into the saved index")
+	}
+	if !bytes.Contains(saved, []byte("giraffe")) {
+		t.Error("Expected plain pages in the saved index")
+	}
+
+	if err := cached.moveFile(wikiDir+"plain.md", wikiDir+"renamed.md"); err != nil {
+		t.Fatal(err)
+	}
+	if res := mustQuery(t, &cached, "giraffe"); len(res) != 1 || res[0].WikiName != "renamed" {
+		t.Errorf("Expected the moved page under its new name but got %+v", res)
+	}
+
+	// A fresh start loads the saved index and re-reads the encrypted page
+	reloaded := newCachedStorage(&fileStorage{TagDir: tagDir}, wikiDir, tagDir)
+	reloaded.enableSearch(indexFile)
+	if res := mustQuery(t, &reloaded, "zebra"); len(res) != 2 {
+		t.Errorf("Expected 2 hits after reloading but got %+v", res)
+	}
+	if res := mustQuery(t, &reloaded, "giraffe"); len(res) != 1 || res[0].WikiName != "renamed" {
+		t.Errorf("Expected the moved page after reloading but got %+v", res)
+	}
+}
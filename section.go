@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// sectionBounds finds the lines of a body that make up the section under a
+// heading, from the heading up to the next heading at the same level or
+// above.  end is one past the last line.
+func sectionBounds(body, id string) (start, end int, ok bool) {
+	headings := pageHeadings(body)
+	i := findHeading(headings, id)
+	if i < 0 {
+		return 0, 0, false
+	}
+	start, end = headings[i].Line, len(strings.Split(body, "\n"))
+	for _, h := range headings[i+1:] {
+		if h.Level <= headings[i].Level {
+			end = h.Line
+			break
+		}
+	}
+	return start, end, true
+}
+
+// pageSection gives the markdown of the section under a heading
+func pageSection(body, id string) (string, bool) {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	start, end, ok := sectionBounds(body, id)
+	if !ok {
+		return "", false
+	}
+	return strings.Join(strings.Split(body, "\n")[start:end], "\n"), true
+}
+
+// replaceSection splices an edited section into a body in place of the
+// section under a heading.  The blank lines between the section and the
+// next heading are kept as they were.
+func replaceSection(body, id, section string) (string, bool) {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	start, end, ok := sectionBounds(body, id)
+	if !ok {
+		return body, false
+	}
+	lines := strings.Split(body, "\n")
+	section = strings.ReplaceAll(section, "\r\n", "\n")
+	if end < len(lines) {
+		blanks := 0
+		for end-1-blanks > start && strings.TrimSpace(lines[end-1-blanks]) == "" {
+			blanks++
+		}
+		section = strings.TrimRight(section, "\n") + strings.Repeat("\n", blanks)
+	}
+
+	spliced := append([]string{}, lines[:start]...)
+	spliced = append(spliced, section)
+	spliced = append(spliced, lines[end:]...)
+	return strings.Join(spliced, "\n"), true
+}
+
+// withSection gives a copy of the page with one section replaced, false if
+// the page has no such section
+func (p *wikiPage) withSection(id, section string) (*wikiPage, bool) {
+	body, ok := replaceSection(string(p.Body), id, section)
+	if !ok {
+		return nil, false
+	}
+	edited := *p
+	edited.Body = template.HTML(body)
+	return &edited, true
+}
+
+var renderedHeading = regexp.MustCompile(`(<h[1-6] id="([^"]+)">.*?)(</h[1-6]>)`)
+
+// sectionEditLinks adds an edit link to each rendered heading that starts a
+// section of the page
+func sectionEditLinks(rendered []byte, title string, headings []heading) []byte {
+	return renderedHeading.ReplaceAllFunc(rendered, func(h []byte) []byte {
+		m := renderedHeading.FindSubmatch(h)
+		id := string(m[2])
+		if findHeading(headings, id) < 0 {
+			return h
+		}
+		return []byte(fmt.Sprintf(`%s <a class="section-edit" href="/wiki/edit/%s?section=%s">edit</a>%s`, m[1], title, id, m[3]))
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const sectionBody = "Intro\n\n# One\n\nFirst\n\n## One A\n\nNested\n\n# Two\n\nSecond\n"
+
+func TestPageSection(t *testing.T) {
+	for id, expected := range map[string]string{
+		"one":     "# One\n\nFirst\n\n## One A\n\nNested\n",
+		"One A":   "## One A\n\nNested\n",
+		"two":     "# Two\n\nSecond\n",
+		"nowhere": "",
+	} {
+		if section, _ := pageSection(sectionBody, id); section != expected {
+			t.Errorf("Expected section %v to be %q but got %q", id, expected, section)
+		}
+	}
+
+	for id, expected := range map[string]string{
+		"one-a": "Intro\n\n# One\n\nFirst\n\n## One A\n\nChanged\n\n# Two\n\nSecond\n",
+		"two":   "Intro\n\n# One\n\nFirst\n\n## One A\n\nNested\n\n## Two\n\nChanged",
+	} {
+		edited := "## One A\n\nChanged"
+		if id == "two" {
+			edited = "## Two\r\n\r\nChanged"
+		}
+		if body, ok := replaceSection(sectionBody, id, edited); !ok || body != expected {
+			t.Errorf("Expected %q replacing %v but got %q", expected, id, body)
+		}
+	}
+	if _, ok := replaceSection(sectionBody, "nowhere", "x"); ok {
+		t.Error("Expected no replacement for a missing section")
+	}
+}
+
+func TestSectionEditing(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+	if err := (&wikiPage{basePage: basePage{Title: "page"}, Body: sectionBody, Tags: "keep", Published: true}).save(&fs); err != nil {
+		t.Fatal(err)
+	}
+	page := func() *wikiPage {
+		p, err := fs.getPage(&wikiPage{basePage: basePage{Title: "page"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	w := httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/page", nil), &wikiPage{basePage: basePage{Title: "page"}}, &fs)
+	if !strings.Contains(w.Body.String(), `href="/wiki/edit/page?section=one-a"`) {
+		t.Errorf("Expected edit links on the headings but got %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	editHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/edit/page?section=two", nil), &wikiPage{basePage: basePage{Title: "page"}}, &fs)
+	if !strings.Contains(w.Body.String(), "# Two\n\nSecond") || strings.Contains(w.Body.String(), "First") || !strings.Contains(w.Body.String(), `name="section" value="two"`) {
+		t.Errorf("Expected just the section in the editor but got %v", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	editHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/edit/page?section=nowhere", nil), &wikiPage{basePage: basePage{Title: "page"}}, &fs)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a missing section, got %v", w.Code)
+	}
+
+	save := func(version, body string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Add("body", body)
+		form.Add("version", version)
+		form.Add("section", "two")
+		req := httptest.NewRequest("POST", "http://localhost/wiki/save/page", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		saveHandler(w, req, "page", &fs)
+		return w
+	}
+	stale := page().Version
+	if w := save(stale, "# Two\r\n\r\nEdited"); w.Code != http.StatusFound {
+		t.Fatalf("Expected the section to save, got %v: %v", w.Code, w.Body.String())
+	}
+	p := page()
+	if string(p.Body) != "Intro\n\n# One\n\nFirst\n\n## One A\n\nNested\n\n# Two\n\nEdited" || p.Tags != "keep" || !p.Published {
+		t.Errorf("Expected the section spliced in with the rest kept but got %+v", p)
+	}
+
+	if w := save(stale, "# Two\n\nAgain"); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "Again") {
+		t.Errorf("Expected a conflict saving against an old version, got %v: %v", w.Code, w.Body.String())
+	}
+	if strings.Contains(string(page().Body), "Again") {
+		t.Error("Expected nothing saved on a conflict")
+	}
+
+	// The API reads and writes sections the same way
+	w = httptest.NewRecorder()
+	innerAPIHandler(w, httptest.NewRequest("GET", "http://localhost/api?wiki=page&section=one", nil), &fs)
+	var got wikiPage
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Body) != "# One\n\nFirst\n\n## One A\n\nNested\n" || got.Version != page().Version {
+		t.Errorf("Expected the section from the API but got %+v", got)
+	}
+
+	post := func(version string) int {
+		data, _ := json.Marshal(map[string]string{"Body": "# One\n\nReplaced\n", "Version": version})
+		w := httptest.NewRecorder()
+		innerAPIHandler(w, httptest.NewRequest("POST", "http://localhost/api?wiki=page&section=one", bytes.NewReader(data)), &fs)
+		return w.Code
+	}
+	if code := post(""); code != http.StatusBadRequest {
+		t.Errorf("Expected a version to be needed, got %v", code)
+	}
+	if code := post(stale); code != http.StatusConflict {
+		t.Errorf("Expected a conflict for an old version, got %v", code)
+	}
+	if code := post(got.Version); code != http.StatusOK {
+		t.Errorf("Expected the section saved, got %v", code)
+	}
+	if string(page().Body) != "Intro\n\n# One\n\nReplaced\n\n# Two\n\nEdited" {
+		t.Errorf("Expected the API edit spliced in but got %q", page().Body)
+	}
+}
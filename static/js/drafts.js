@@ -0,0 +1,59 @@
+document.addEventListener('DOMContentLoaded', function() {
+  const form = document.getElementById('wikieditform');
+  const editor = document.getElementById('wikiedit');
+  if (!form || !editor) return;
+
+  const title = form.dataset.title;
+  const draftURL = '/api?draft=' + encodeURIComponent(title);
+  const autosaveInterval = 30000;
+  let lastSaved = editor.value;
+
+  function fieldValue(name) {
+      const field = form.elements[name];
+      return field ? field.value : '';
+  }
+
+  function saveDraft() {
+      if (editor.value === lastSaved) return;
+      const body = editor.value;
+      const crypt = document.getElementById('wikicrypt');
+      fetch(draftURL, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({
+              Body: body,
+              Version: fieldValue('version'),
+              Section: fieldValue('section'),
+              Encrypted: crypt ? crypt.checked : false
+          })
+      })
+      .then(function(response) {
+          if (response.ok) {
+              lastSaved = body;
+          }
+      })
+      .catch(function(error) {
+          console.error('Error saving draft:', error);
+      });
+  }
+
+  setInterval(saveDraft, autosaveInterval);
+
+  const banner = document.getElementById('draft-banner');
+  if (!banner) return;
+
+  document.getElementById('draft-resume').addEventListener('click', function() {
+      editor.value = document.getElementById('draft-body').value;
+      editor.dispatchEvent(new Event('input'));
+      banner.style.display = 'none';
+      editor.focus();
+  });
+
+  document.getElementById('draft-discard').addEventListener('click', function() {
+      fetch(draftURL, { method: 'DELETE' })
+      .catch(function(error) {
+          console.error('Error discarding draft:', error);
+      });
+      banner.style.display = 'none';
+  });
+});
@@ -0,0 +1,41 @@
+document.addEventListener('DOMContentLoaded', function() {
+  const editor = document.getElementById('wikiedit');
+  const preview = document.getElementById('preview');
+  if (!editor || !preview) return;
+
+  const previewURL = '/api?preview=' + encodeURIComponent(preview.dataset.title);
+  const previewDelay = 500;
+  let timer = null;
+  let latest = 0;
+
+  function updatePreview() {
+      // Responses can arrive out of order so only the newest is shown
+      const request = ++latest;
+      fetch(previewURL, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ Body: editor.value })
+      })
+      .then(function(response) {
+          if (!response.ok) {
+              throw new Error('Preview failed: ' + response.status);
+          }
+          return response.json();
+      })
+      .then(function(data) {
+          if (request === latest) {
+              preview.innerHTML = data.html;
+          }
+      })
+      .catch(function(error) {
+          console.error('Error previewing page:', error);
+      });
+  }
+
+  editor.addEventListener('input', function() {
+      clearTimeout(timer);
+      timer = setTimeout(updatePreview, previewDelay);
+  });
+
+  updatePreview();
+});
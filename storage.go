@@ -30,6 +30,11 @@ type storage interface {
 	getPublicPages() []string
 	getPage(p *wikiPage) (*wikiPage, error)
 	searchPages(root, query string) []string
+	queryPages(query string, offset, limit int) ([]QueryResults, int, error)
+	getLinks(title string) []string
+	getBacklinks(title string) []string
+	getAlias(name string) string
+	getAliases(title string) []string
 	checkForPDF(p *wikiPage) (*wikiPage, error)
 	IndexTags(path string) TagIndex
 	GetTagWikis(tag string) Tag
@@ -38,6 +43,9 @@ type storage interface {
 	getWikiList(from string) []string
 	storeImage(wikiTitle string, imageData []byte, extension string) (string, error)
 	storeResizedImage(wikiTitle string, imageData []byte, extension string, width, height int) (string, error)
+	storeRevision(title string) error
+	getRevisions(title string) []revision
+	getRevision(title, id string) (revision, error)
 }
 
 // StorageConfig holds configuration for file storage
@@ -110,6 +118,31 @@ func (cs *ConfigurableStorage) searchPages(root, query string) []string {
 	return cs.fs.searchPages(root, query)
 }
 
+func (cs *ConfigurableStorage) queryPages(query string, offset, limit int) ([]QueryResults, int, error) {
+	defer cs.swapGlobals()()
+	return cs.fs.queryPages(query, offset, limit)
+}
+
+func (cs *ConfigurableStorage) getLinks(title string) []string {
+	defer cs.swapGlobals()()
+	return cs.fs.getLinks(title)
+}
+
+func (cs *ConfigurableStorage) getBacklinks(title string) []string {
+	defer cs.swapGlobals()()
+	return cs.fs.getBacklinks(title)
+}
+
+func (cs *ConfigurableStorage) getAlias(name string) string {
+	defer cs.swapGlobals()()
+	return cs.fs.getAlias(name)
+}
+
+func (cs *ConfigurableStorage) getAliases(title string) []string {
+	defer cs.swapGlobals()()
+	return cs.fs.getAliases(title)
+}
+
 func (cs *ConfigurableStorage) checkForPDF(p *wikiPage) (*wikiPage, error) {
 	// Replace wikiDir with config.WikiDir
 	originalWikiDir := wikiDir
@@ -162,6 +195,51 @@ func (cs *ConfigurableStorage) storeResizedImage(wikiTitle string, imageData []b
 	return cs.fs.storeResizedImage(wikiTitle, imageData, extension, width, height)
 }
 
+// swapGlobals points the package level folders and key at this storage's
+// config and returns a func that puts the originals back
+func (cs *ConfigurableStorage) swapGlobals() func() {
+	originalWikiDir := wikiDir
+	originalTagDir := tagDir
+	originalPubDir := pubDir
+	originalHistDir := histDir
+	originalAliasDir := aliasDir
+	originalTrashDir := trashDir
+	originalEkey := ekey
+
+	wikiDir = cs.config.WikiDir
+	tagDir = cs.config.TagDir
+	pubDir = cs.config.PubDir
+	histDir = cs.config.WikiDir + "history/"
+	aliasDir = cs.config.WikiDir + "aliases/"
+	trashDir = cs.config.WikiDir + "trash/"
+	ekey = cs.config.EncKey
+
+	return func() {
+		wikiDir = originalWikiDir
+		tagDir = originalTagDir
+		pubDir = originalPubDir
+		histDir = originalHistDir
+		aliasDir = originalAliasDir
+		trashDir = originalTrashDir
+		ekey = originalEkey
+	}
+}
+
+func (cs *ConfigurableStorage) storeRevision(title string) error {
+	defer cs.swapGlobals()()
+	return cs.fs.storeRevision(title)
+}
+
+func (cs *ConfigurableStorage) getRevisions(title string) []revision {
+	defer cs.swapGlobals()()
+	return cs.fs.getRevisions(title)
+}
+
+func (cs *ConfigurableStorage) getRevision(title, id string) (revision, error) {
+	defer cs.swapGlobals()()
+	return cs.fs.getRevision(title, id)
+}
+
 type fileStorage struct {
 	TagDir string
 }
@@ -198,6 +276,13 @@ func (fst *fileStorage) deleteFile(name string) error {
 	return nil
 }
 func (fst *fileStorage) moveFile(from, to string) error {
+	// Don't leave empty folders behind for things that aren't there
+	if _, err := os.Stat(from); err != nil {
+		return err
+	}
+	if err := createDir(to); err != nil {
+		return err
+	}
 	if err := os.Rename(from, to); err != nil {
 		return err
 	}
@@ -220,7 +305,7 @@ func indexPubPages(path string) []string {
 }
 
 func (fst *fileStorage) getPublicPages() []string {
-	return indexPubPages(pubDir)
+	return publicPages(indexPubPages(pubDir), frontMatters(wikiDir))
 }
 
 func (fst *fileStorage) getPage(p *wikiPage) (*wikiPage, error) {
@@ -238,6 +323,7 @@ func (fst *fileStorage) getPage(p *wikiPage) (*wikiPage, error) {
 		log.Println(err)
 		return p, err
 	}
+	p.Version = pageVersion(body)
 	if bytes.HasPrefix(body, encryptionFlag) {
 		tmp := bytes.TrimPrefix(body, encryptionFlag)
 
@@ -248,6 +334,7 @@ func (fst *fileStorage) getPage(p *wikiPage) (*wikiPage, error) {
 		}
 		p.Encrypted = true
 	}
+	fm, body := splitFrontMatter(body)
 	p.Body = template.HTML(body)
 
 	info, err := file.Stat()
@@ -256,7 +343,13 @@ func (fst *fileStorage) getPage(p *wikiPage) (*wikiPage, error) {
 		return p, err
 	}
 
-	p.Modified = info.ModTime().String()
+	dates := pageTimes(p.Title, info)
+	if !dates.Created.IsZero() {
+		p.Created = dates.Created.Format(time.RFC3339)
+	}
+	p.Modified = dates.Modified.Format(time.RFC3339)
+	p.CreatedBy = dates.CreatedBy
+	p.ModifiedBy = dates.ModifiedBy
 
 	tags, err := os.ReadFile(getWikiTagsFilename(p.Title))
 	if err == nil {
@@ -272,6 +365,10 @@ func (fst *fileStorage) getPage(p *wikiPage) (*wikiPage, error) {
 		pubfile.Close()
 	}
 
+	if fm != nil {
+		fm.apply(p)
+	}
+
 	return p, nil
 }
 
@@ -280,6 +377,9 @@ func (fst *fileStorage) searchPages(root string, query string) []string {
 	results := make(chan string)
 
 	filepath.WalkDir(root, func(path string, file fs.DirEntry, err error) error {
+		if file.IsDir() && path != root && isSpecialDir(root, path) {
+			return filepath.SkipDir
+		}
 		if !file.IsDir() {
 			wg.Add(1)
 			name := strings.TrimSuffix(strings.TrimPrefix(path, root), ".md")
@@ -298,6 +398,26 @@ func (fst *fileStorage) searchPages(root string, query string) []string {
 	}
 	return hits
 }
+// Without a persistent index searches and links are worked out from a
+// throwaway one built by reading every page
+func (fst *fileStorage) tempIndex() *searchIndex {
+	idx := newSearchIndex("")
+	idx.refresh(fst, wikiDir)
+	return idx
+}
+
+func (fst *fileStorage) queryPages(query string, offset, limit int) ([]QueryResults, int, error) {
+	return fst.tempIndex().query(fst, query, offset, limit)
+}
+
+func (fst *fileStorage) getLinks(title string) []string {
+	return fst.tempIndex().links(title)
+}
+
+func (fst *fileStorage) getBacklinks(title string) []string {
+	return fst.tempIndex().backlinks(title)
+}
+
 func readFile(wg *sync.WaitGroup, name string, path string, query string, results chan string) {
 	defer wg.Done()
 
@@ -334,7 +454,11 @@ func (fst *fileStorage) checkForPDF(p *wikiPage) (*wikiPage, error) {
 func (fst *fileStorage) IndexTags(path string) TagIndex {
 	index := TagIndex(make(map[string]Tag))
 
-	err := filepath.WalkDir(path, func(subpath string, info fs.DirEntry, _ error) error {
+	err := filepath.WalkDir(path, func(subpath string, info fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			// e.g. no tags folder yet
+			return nil
+		}
 		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
 			contents, err := os.ReadFile(subpath)
 			checkErr(err)
@@ -348,6 +472,13 @@ func (fst *fileStorage) IndexTags(path string) TagIndex {
 	})
 	checkErr(err)
 
+	// Tags in a page's front matter win over its tags file
+	for title, fm := range frontMatters(wikiDir) {
+		if fm.Tags != nil {
+			index.SetWikiTags(title, fm.Tags)
+		}
+	}
+
 	return index
 }
 func (fst *fileStorage) GetTagWikis(tag string) Tag {
@@ -371,10 +502,67 @@ func (fst *fileStorage) IndexRawFiles(path, fileExtension string, existing TagIn
 
 }
 
+// isSpecialDir checks whether path is one of the top level folders the wiki
+// keeps its own data in
+func isSpecialDir(root, path string) bool {
+	rel := strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+	return contains(rel, specialDir) && rel == filepath.Base(path)
+}
+
 func genID(base, name string) string {
 	return strings.ReplaceAll(base+name, "/", "-")
 }
 
+// navExtensions are the files that belong in the menu.  They are matched
+// exactly, so e.g. X.PDF is left out.
+var navExtensions = []string{".md", ".txt", ".pdf"}
+
+// navEntry builds the menu entry for a file in the wiki folder.  Only md, txt
+// and pdf files belong in the menu, ok is false for anything else.
+func navEntry(base string, info fs.FileInfo) (wikiNav, bool) {
+	if info.IsDir() || !contains(filepath.Ext(info.Name()), navExtensions) {
+		return wikiNav{}, false
+	}
+	// Ignore anything that isnt an md file
+	if strings.HasSuffix(info.Name(), ".md") {
+		name := strings.TrimSuffix(info.Name(), ".md")
+		title := strings.TrimPrefix(base+"/"+name, "/")
+		mod := pageTimes(title, info).Modified
+		return wikiNav{
+			Name:    name,
+			URL:     base + "/" + name,
+			Mod:     mod,
+			ModStr:  mod.Local().Format(TIME_FORMAT),
+			ID:      genID(base, name),
+			Summary: fileSummary(getWikiFilename(wikiDir, title)),
+			file:    info.Name(),
+		}, true
+	}
+	if strings.HasSuffix(info.Name(), ".txt") {
+		name := strings.TrimSuffix(info.Name(), ".txt")
+		return wikiNav{
+			Name:    name,
+			URL:     base + "/" + name,
+			Mod:     info.ModTime(),
+			ModStr:  info.ModTime().Format(TIME_FORMAT),
+			ID:      genID(base, name),
+			Summary: fileSummary(wikiDir + strings.TrimPrefix(base+"/", "/") + info.Name()),
+			file:    info.Name(),
+		}, true
+	}
+	if strings.HasSuffix(info.Name(), ".pdf") {
+		return wikiNav{
+			Name:   info.Name(),
+			URL:    base + "/" + info.Name(),
+			Mod:    info.ModTime(),
+			ModStr: info.ModTime().Format(TIME_FORMAT),
+			ID:     genID(base, info.Name()),
+			file:   info.Name(),
+		}, true
+	}
+	return wikiNav{}, false
+}
+
 // IndexWikiFiles will crawl through picking out files that conform to requirements for wiki entries
 // This includes md and pdf files.
 // Any hidden (dot) files are skipped
@@ -396,39 +584,7 @@ func (fst *fileStorage) IndexWikiFiles(base, path string) []wikiNav {
 		info, err := f.Info()
 		checkErr(err)
 
-		// Ignore anything that isnt an md file
-		if strings.HasSuffix(f.Name(), ".md") {
-			name := strings.TrimSuffix(f.Name(), ".md")
-			tmp := wikiNav{
-				Name:    name,
-				URL:     base + "/" + name,
-				Mod:     info.ModTime(),
-				ModStr:  info.ModTime().Format(TIME_FORMAT),
-				ID:      genID(base, name),
-				Summary: "This is a test summary for markdown",
-			}
-			names = append(names, tmp)
-		}
-		if strings.HasSuffix(f.Name(), ".txt") {
-			name := strings.TrimSuffix(f.Name(), ".txt")
-			tmp := wikiNav{
-				Name:    name,
-				URL:     base + "/" + name,
-				Mod:     info.ModTime(),
-				ModStr:  info.ModTime().Format(TIME_FORMAT),
-				ID:      genID(base, name),
-				Summary: "This is a test summary for text file",
-			}
-			names = append(names, tmp)
-		}
-		if strings.HasSuffix(f.Name(), ".pdf") {
-			tmp := wikiNav{
-				Name:   f.Name(),
-				URL:    base + "/" + f.Name(),
-				Mod:    info.ModTime(),
-				ModStr: info.ModTime().Format(TIME_FORMAT),
-				ID:     genID(base, f.Name()),
-			}
+		if tmp, ok := navEntry(base, info); ok {
 			names = append(names, tmp)
 		}
 		if f.IsDir() {
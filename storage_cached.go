@@ -1,63 +1,470 @@
 package main
 
-import "log"
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
 
+// cachedStorage keeps the tag index, raw file index and nav tree in memory.
+// Writes made through it update the cached copies in place where it can
+// work out what changed, falling back to a full rebuild otherwise.  Cached
+// values are never changed once handed out, updates build new copies and
+// swap them in under the lock.
 type cachedStorage struct {
-	fileStorage
+	storage
 	wikiDir         string
 	tagDir          string
 	cachedTagIndex  TagIndex
 	cachedRawFiles  TagIndex
 	cachedWikiIndex []wikiNav
+	// cachedFrontMatter is the front matter of each page that has some,
+	// which can say whether it is published
+	cachedFrontMatter map[string]*frontMatter
+	// cachedAliases are the aliases that point at each page
+	cachedAliases map[string][]string
+	mu            sync.RWMutex
+	rebuildMu     sync.Mutex
+	rebuilding    bool
+	// rebuilds are the rebuilds running in the background
+	rebuilds sync.WaitGroup
+	missed   []string
+	search   *searchIndex
 }
 
-func newCachedStorage(fs fileStorage, wd, td string) cachedStorage {
+func newCachedStorage(fs storage, wd, td string) cachedStorage {
 	ti := fs.IndexTags(td)
 	rf := fs.IndexRawFiles(wd, "PDF", ti)
 	wi := fs.IndexWikiFiles("", wd)
+	fms := frontMatters(wd)
+	aliases := indexAliases(aliasDir)
 
-	return cachedStorage{fs, wd, td, ti, rf, wi}
+	return cachedStorage{storage: fs, wikiDir: wd, tagDir: td, cachedTagIndex: ti, cachedRawFiles: rf, cachedWikiIndex: wi, cachedFrontMatter: fms, cachedAliases: aliases}
 }
 
+// rebuildCache re-reads everything from disk.  Targeted updates that land
+// while the rebuild is reading may have been missed, so they are noted and
+// applied again on top of the rebuilt copies.  Only one rebuild runs at a
+// time.
 func (cs *cachedStorage) rebuildCache() {
+	cs.rebuildMu.Lock()
+	defer cs.rebuildMu.Unlock()
+
+	cs.mu.Lock()
+	cs.rebuilding = true
+	cs.missed = nil
+	cs.mu.Unlock()
+
 	log.Println("[cache] wiki cache rebuild")
-	cs.cachedTagIndex = cs.fileStorage.IndexTags(cs.tagDir)
-	cs.cachedRawFiles = cs.fileStorage.IndexRawFiles(cs.wikiDir, "PDF", cs.cachedTagIndex)
-	cs.cachedWikiIndex = cs.fileStorage.IndexWikiFiles("", cs.wikiDir)
+	ti := cs.storage.IndexTags(cs.tagDir)
+	rf := cs.storage.IndexRawFiles(cs.wikiDir, "PDF", ti)
+	wi := cs.storage.IndexWikiFiles("", cs.wikiDir)
+	fms := frontMatters(cs.wikiDir)
+	aliases := indexAliases(aliasDir)
+
+	cs.mu.Lock()
+	cs.cachedTagIndex = ti
+	cs.cachedRawFiles = rf
+	cs.cachedWikiIndex = wi
+	cs.cachedFrontMatter = fms
+	cs.cachedAliases = aliases
+	missed := cs.missed
+	cs.rebuilding = false
+	cs.missed = nil
+	cs.mu.Unlock()
+
+	for _, name := range missed {
+		_, err := os.Stat(name)
+		cs.applyChange(name, err == nil)
+	}
+	if cs.search != nil {
+		cs.search.refresh(cs.storage, cs.wikiDir)
+	}
 }
 
 func (cs *cachedStorage) IndexWikiFiles(base, path string) []wikiNav {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
 	return cs.cachedWikiIndex
 }
 
 func (cs *cachedStorage) IndexTags(path string) TagIndex {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
 	return cs.cachedTagIndex
 }
 
 func (cs *cachedStorage) IndexRawFiles(path, fileExtension string, existing TagIndex) TagIndex {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
 	return cs.cachedRawFiles
 }
 
+// getPublicPages combines the pub markers with the cached front matter
+// rather than reading every page
+func (cs *cachedStorage) getPublicPages() []string {
+	cs.mu.RLock()
+	fms := cs.cachedFrontMatter
+	cs.mu.RUnlock()
+	return publicPages(indexPubPages(pubDir), fms)
+}
+
+// getAliases looks the page up in the cached aliases rather than reading
+// every alias
+func (cs *cachedStorage) getAliases(title string) []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return append([]string{}, cs.cachedAliases[title]...)
+}
+
+func (cs *cachedStorage) GetTagWikis(tag string) Tag {
+	return cs.IndexTags(cs.tagDir)[tag]
+}
+
+// enableSearch loads the saved search index from file (an empty file name
+// keeps it in memory only) and brings it up to date with the wiki
+func (cs *cachedStorage) enableSearch(file string) {
+	cs.search = newSearchIndex(file)
+	cs.search.load()
+	cs.search.refresh(cs.storage, cs.wikiDir)
+}
+
+// queryPages uses the search index when there is one, most relevant first
+func (cs *cachedStorage) queryPages(query string, offset, limit int) ([]QueryResults, int, error) {
+	if cs.search == nil {
+		return cs.storage.queryPages(query, offset, limit)
+	}
+	return cs.search.query(cs.storage, query, offset, limit)
+}
+
+func (cs *cachedStorage) getLinks(title string) []string {
+	if cs.search == nil {
+		return cs.storage.getLinks(title)
+	}
+	return cs.search.links(title)
+}
+
+func (cs *cachedStorage) hasLinkIndex() bool {
+	return cs.search != nil
+}
+
+func (cs *cachedStorage) getBacklinks(title string) []string {
+	if cs.search == nil {
+		return cs.storage.getBacklinks(title)
+	}
+	return cs.search.backlinks(title)
+}
+
+// updateSearch re-indexes the page a changed file belongs to, whether that
+// is the page itself, its tags or its published marker
+func (cs *cachedStorage) updateSearch(name string) {
+	if cs.search == nil {
+		return
+	}
+	var title string
+	kind, rel := cs.classify(name)
+	switch {
+	case kind == cacheTags:
+		title = rel
+	case kind == cacheNav && strings.HasSuffix(rel, ".md"):
+		title = strings.TrimSuffix(rel, ".md")
+	case strings.HasPrefix(rel, "pub/"):
+		title = strings.TrimPrefix(rel, "pub/")
+	default:
+		return
+	}
+
+	info, err := os.Stat(getWikiFilename(cs.wikiDir, title))
+	if err != nil {
+		cs.search.remove(title)
+	} else {
+		cs.search.indexPage(cs.storage, title, info)
+	}
+	cs.search.saveLater()
+}
+
+// applyExternal brings the cache up to date with files changed outside the
+// app, as reported by the watcher, including tags and published markers
+// synced in after their pages.  Files the app wrote itself come round again
+// here but only cost the same targeted update.
+func (cs *cachedStorage) applyExternal(names []string) {
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err == nil && info.IsDir() {
+			// The files in a new folder are reported on their own
+			continue
+		}
+		cs.applyChange(name, err == nil)
+		cs.updateSearch(name)
+	}
+}
+
 func (cs *cachedStorage) clearCache() error {
-	// Original async implementation - rebuilds cache in background
-	go cs.rebuildCache()
+	// Fallback for changes we can't apply directly - rebuilds in background
+	cs.rebuilds.Add(1)
+	go func() {
+		defer cs.rebuilds.Done()
+		cs.rebuildCache()
+	}()
 	return nil
 }
+
+// cachedFile says what a file in the wiki folder means to the cache
+type cachedFile int
+
+const (
+	cacheIgnore cachedFile = iota
+	cacheTags
+	cacheNav
+	cacheUnknown
+)
+
+// classify works out what kind of file name is and its path relative to
+// either the tags folder or the wiki folder
+func (cs *cachedStorage) classify(name string) (cachedFile, string) {
+	if strings.HasPrefix(name, cs.tagDir) {
+		return cacheTags, strings.TrimPrefix(name, cs.tagDir)
+	}
+	if !strings.HasPrefix(name, cs.wikiDir) {
+		return cacheUnknown, name
+	}
+	rel := strings.TrimPrefix(name, cs.wikiDir)
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		// Matches what IndexWikiFiles skips
+		if strings.HasPrefix(p, ".") || (i < len(parts)-1 && contains(p, specialDir)) {
+			return cacheIgnore, rel
+		}
+	}
+	if contains(filepath.Ext(rel), navExtensions) {
+		return cacheNav, rel
+	}
+	return cacheUnknown, rel
+}
+
+// applyChange updates the cache for a file that has been written, or
+// removed when exists is false
+func (cs *cachedStorage) applyChange(name string, exists bool) {
+	if aliasDir != "" && strings.HasPrefix(name, aliasDir) {
+		cs.applyAlias(strings.TrimPrefix(name, aliasDir), exists)
+		return
+	}
+	kind, rel := cs.classify(name)
+	if kind == cacheIgnore && strings.HasPrefix(rel, "meta/") {
+		// A page's dates decide where it sits in the menu so treat it as
+		// the page changing, if it is still there
+		name = getWikiFilename(cs.wikiDir, strings.TrimPrefix(rel, "meta/"))
+		if _, err := os.Stat(name); err != nil {
+			return
+		}
+		kind, rel = cs.classify(name)
+		exists = true
+	}
+	switch kind {
+	case cacheIgnore:
+		return
+	case cacheUnknown:
+		cs.clearCache()
+		return
+	}
+
+	// The file is read under the lock so changes to the same page are
+	// applied in the order they happen
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.rebuilding {
+		cs.missed = append(cs.missed, name)
+	}
+
+	var info os.FileInfo
+	if exists {
+		var err error
+		if info, err = os.Stat(name); err != nil {
+			log.Printf("[cache] %v", err)
+			cs.clearCache()
+			return
+		}
+	}
+	tagged := ""
+	switch {
+	case kind == cacheTags:
+		tagged = rel
+	case strings.HasSuffix(rel, ".md"):
+		tagged = strings.TrimSuffix(rel, ".md")
+	}
+
+	if tagged != "" {
+		// Tags can come from the page's front matter or its tags file so
+		// a change to either means looking at both
+		tags := readPageTags(cs.wikiDir, cs.tagDir, tagged)
+		// The raw file index is built on top of the tag index so they share
+		ti := cs.cachedTagIndex.Clone()
+		ti.SetWikiTags(tagged, tags)
+		cs.cachedTagIndex = ti
+		cs.cachedRawFiles = ti
+	}
+
+	if kind == cacheNav && strings.HasSuffix(rel, ".md") {
+		fms := make(map[string]*frontMatter, len(cs.cachedFrontMatter)+1)
+		for title, fm := range cs.cachedFrontMatter {
+			fms[title] = fm
+		}
+		delete(fms, tagged)
+		if fm := readFrontMatter(name); exists && fm != nil {
+			fms[tagged] = fm
+		}
+		cs.cachedFrontMatter = fms
+	}
+
+	switch kind {
+	case cacheNav:
+		parts := strings.Split(rel, "/")
+		if exists {
+			cs.cachedWikiIndex = navInsert(cs.cachedWikiIndex, "", parts[:len(parts)-1], info)
+		} else {
+			cs.cachedWikiIndex = navRemove(cs.cachedWikiIndex, parts)
+		}
+		if strings.HasSuffix(rel, ".pdf") {
+			ti := cs.cachedTagIndex.Clone()
+			if exists {
+				ti.SetWikiTags(rel, []string{"PDF"})
+			} else {
+				ti.RemoveWiki(rel)
+			}
+			cs.cachedTagIndex = ti
+			cs.cachedRawFiles = ti
+		}
+	}
+}
+
+// applyAlias updates the cached aliases for an alias that has been written,
+// or removed when exists is false
+func (cs *cachedStorage) applyAlias(alias string, exists bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.rebuilding {
+		cs.missed = append(cs.missed, getWikiAliasFilename(alias))
+	}
+
+	aliases := make(map[string][]string, len(cs.cachedAliases)+1)
+	for title, list := range cs.cachedAliases {
+		if contains(alias, list) {
+			rest := []string{}
+			for _, a := range list {
+				if a != alias {
+					rest = append(rest, a)
+				}
+			}
+			if len(rest) == 0 {
+				continue
+			}
+			list = rest
+		}
+		aliases[title] = list
+	}
+	if exists {
+		if title := cs.storage.getAlias(alias); title != "" {
+			list := append(append([]string{}, aliases[title]...), alias)
+			sort.Strings(list)
+			aliases[title] = list
+		}
+	}
+	cs.cachedAliases = aliases
+}
+
+func sortNav(names []wikiNav) {
+	sort.Sort(sort.Reverse(byModTime(names)))
+}
+
+// navInsert returns a copy of the nav tree with the entry for a file added
+// (or replaced) in the folder given by dirs, creating folders as needed
+func navInsert(tree []wikiNav, base string, dirs []string, info os.FileInfo) []wikiNav {
+	names := make([]wikiNav, 0, len(tree)+1)
+	if len(dirs) == 0 {
+		entry, ok := navEntry(base, info)
+		if !ok {
+			return tree
+		}
+		for _, n := range tree {
+			if n.IsDir || n.file != entry.file {
+				names = append(names, n)
+			}
+		}
+		names = append(names, entry)
+		sortNav(names)
+		return names
+	}
+
+	found := false
+	for _, n := range tree {
+		if n.IsDir && n.Name == dirs[0] {
+			n.SubNav = navInsert(n.SubNav, base+"/"+dirs[0], dirs[1:], info)
+			n.Mod = n.SubNav[0].Mod
+			found = true
+		}
+		names = append(names, n)
+	}
+	if !found {
+		dir := wikiNav{
+			Name:  dirs[0],
+			URL:   base + "/" + dirs[0],
+			IsDir: true,
+			ID:    genID(base, dirs[0]),
+		}
+		dir.SubNav = navInsert(nil, dir.URL, dirs[1:], info)
+		dir.Mod = dir.SubNav[0].Mod
+		names = append(names, dir)
+	}
+	sortNav(names)
+	return names
+}
+
+// navRemove returns a copy of the nav tree without the file at path.  Folders
+// are left in place, as they are on disk.
+func navRemove(tree []wikiNav, path []string) []wikiNav {
+	names := make([]wikiNav, 0, len(tree))
+	for _, n := range tree {
+		switch {
+		case len(path) > 1 && n.IsDir && n.Name == path[0]:
+			n.SubNav = navRemove(n.SubNav, path[1:])
+			if len(n.SubNav) > 0 {
+				n.Mod = n.SubNav[0].Mod
+			}
+		case len(path) == 1 && !n.IsDir && n.file == path[0]:
+			continue
+		}
+		names = append(names, n)
+	}
+	sortNav(names)
+	return names
+}
+
 func (cs *cachedStorage) storeFile(name string, content []byte) error {
-	if err := cs.fileStorage.storeFile(name, content); err != nil {
+	if err := cs.storage.storeFile(name, content); err != nil {
 		return err
 	}
-	return cs.clearCache()
+	cs.applyChange(name, true)
+	cs.updateSearch(name)
+	return nil
 }
 func (cs *cachedStorage) deleteFile(name string) error {
-	if err := cs.fileStorage.deleteFile(name); err != nil {
+	if err := cs.storage.deleteFile(name); err != nil {
 		return err
 	}
-	return cs.clearCache()
+	cs.applyChange(name, false)
+	cs.updateSearch(name)
+	return nil
 }
 func (cs *cachedStorage) moveFile(from, to string) error {
-	if err := cs.fileStorage.moveFile(from, to); err != nil {
+	if err := cs.storage.moveFile(from, to); err != nil {
 		return err
 	}
-	return cs.clearCache()
+	cs.applyChange(from, false)
+	cs.applyChange(to, true)
+	cs.updateSearch(from)
+	cs.updateSearch(to)
+	return nil
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
-	"testing"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
 	"time"
 )
 
@@ -30,7 +33,7 @@ func TestCachedStorageFunctionality(t *testing.T) {
 	
 	// Create a new cachedStorage
 	cached := cachedStorage{
-		fileStorage: fstorage,
+		storage: &fstorage,
 		wikiDir: wikiDir,
 		tagDir: tagDir,
 		cachedTagIndex: make(TagIndex),
@@ -99,7 +102,7 @@ func TestCacheOperations(t *testing.T) {
 	
 	// Create a test cached storage
 	cached := cachedStorage{
-		fileStorage:     fs,
+		storage:         &fs,
 		wikiDir:         wikiDir,
 		tagDir:          tagDir,
 		cachedTagIndex:  make(TagIndex),
@@ -140,8 +143,8 @@ func TestCacheOperations(t *testing.T) {
 		t.Errorf("moveFile returned error: %v", err)
 	}
 	
-	// Allow some time for any async operations to finish
-	time.Sleep(100 * time.Millisecond)
+	// Let any async rebuild finish
+	cached.rebuilds.Wait()
 }
 
 // TestNewCachedStorage tests the creation of a new cached storage
@@ -167,7 +170,7 @@ func TestNewCachedStorage(t *testing.T) {
 	
 	// Create the cachedStorage manually to avoid file operations
 	cached := cachedStorage{
-		fileStorage:     fs,
+		storage:         &fs,
 		wikiDir:         wikiDir,
 		tagDir:          tagDir,
 		cachedTagIndex:  make(TagIndex),
@@ -238,7 +241,7 @@ func TestRebuildCacheProcess(t *testing.T) {
 	
 	// Create a test cached storage
 	cached := cachedStorage{
-		fileStorage:     fs,
+		storage:         &fs,
 		wikiDir:         wikiDir,
 		tagDir:          tagDir,
 		cachedTagIndex:  make(TagIndex),
@@ -280,4 +283,254 @@ func TestRebuildCacheProcess(t *testing.T) {
 	if !found {
 		t.Error("Added tag wasn't found in the cached results")
 	}
-}
\ No newline at end of file
+}
+// navNames flattens a nav tree into URLs so the cached and rebuilt trees
+// can be compared without worrying about the order of equal mod times
+func navNames(tree []wikiNav) map[string]bool {
+	names := map[string]bool{}
+	for _, n := range tree {
+		names[n.URL] = true
+		for k := range navNames(n.SubNav) {
+			names[k] = true
+		}
+	}
+	return names
+}
+
+// TestCachedStorageIncremental checks targeted updates leave the cache
+// looking the same as a full rebuild would
+func TestCachedStorageIncremental(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	wd := tmpDir + "/"
+	td := wd + "tags/"
+	os.MkdirAll(td, 0755)
+	os.WriteFile(wd+"existing.md", []byte("existing"), 0600)
+
+	originalSpecialDir := specialDir
+	specialDir = []string{"tags", "pub", "history"}
+	defer func() { specialDir = originalSpecialDir }()
+
+	fs := &fileStorage{TagDir: td}
+	cached := newCachedStorage(fs, wd, td)
+
+	steps := []func() error{
+		func() error { return cached.storeFile(wd+"folder/sub/page.md", []byte("page")) },
+		func() error { return cached.storeFile(td+"folder/sub/page", []byte("one,two")) },
+		func() error { return cached.storeFile(wd+"doc.pdf", []byte("%PDF")) },
+		func() error { return cached.storeFile(wd+"pub/folder/sub/page", nil) },
+		func() error { return cached.moveFile(wd+"folder/sub/page.md", wd+"folder/moved.md") },
+		func() error { return cached.moveFile(td+"folder/sub/page", td+"folder/moved") },
+		func() error { return cached.storeFile(td+"existing", []byte("two")) },
+		func() error { return cached.deleteFile(wd + "doc.pdf") },
+		func() error { return cached.storeFile(wd+"SCAN.PDF", []byte("%PDF")) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("Step %v failed: %v", i, err)
+		}
+
+		full := navNames(fs.IndexWikiFiles("", wd))
+		got := navNames(cached.IndexWikiFiles("", wd))
+		if !reflect.DeepEqual(full, got) {
+			t.Errorf("Step %v: nav is %v but a rebuild gives %v", i, got, full)
+		}
+
+		// Some changes are only caught by a rebuild in the background
+		time.Sleep(50 * time.Millisecond)
+		fullTags := fs.IndexRawFiles(wd, "PDF", fs.IndexTags(td))
+		gotTags := cached.IndexTags(td)
+		if !reflect.DeepEqual(fullTags, gotTags) {
+			t.Errorf("Step %v: tags are %v but a rebuild gives %v", i, gotTags, fullTags)
+		}
+	}
+
+	if tag := cached.GetTagWikis("two"); len(tag.Wikis) != 2 {
+		t.Errorf("Expected 2 pages tagged two but got %v", tag.Wikis)
+	}
+}
+
+// TestCachedStorageConcurrent hammers the cache with writes and reads at
+// the same time, run with -race to check the locking
+func TestCachedStorageConcurrent(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	wd := tmpDir + "/"
+	td := wd + "tags/"
+	os.MkdirAll(td, 0755)
+
+	cached := newCachedStorage(&fileStorage{TagDir: td}, wd, td)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				name := fmt.Sprintf("folder%v/page%v", i, j)
+				cached.storeFile(wd+name+".md", []byte("body"))
+				cached.storeFile(td+name, []byte("tag"))
+			}
+			cached.rebuildCache()
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				flattenWikis(cached.IndexWikiFiles("", wd))
+				_ = len(cached.IndexTags(td)["tag"].Wikis)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(flattenWikis(cached.IndexWikiFiles("", wd))); n != 80 {
+		t.Errorf("Expected 80 pages in the nav but got %v", n)
+	}
+	if n := len(cached.GetTagWikis("tag").Wikis); n != 80 {
+		t.Errorf("Expected 80 pages tagged but got %v", n)
+	}
+}
+
+// TestCachedStorageRebuildDuringWrites checks a rebuild finishes even though
+// writes keep arriving, without losing them
+func TestCachedStorageRebuildDuringWrites(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	wd := tmpDir + "/"
+	td := wd + "tags/"
+	os.MkdirAll(td, 0755)
+	cached := newCachedStorage(&fileStorage{TagDir: td}, wd, td)
+
+	stop := make(chan struct{})
+	written := make(chan int)
+	go func() {
+		n := 0
+		for ; ; n++ {
+			select {
+			case <-stop:
+				written <- n
+				return
+			default:
+			}
+			cached.storeFile(fmt.Sprintf("%vpage%v.md", wd, n), []byte("body"))
+			cached.storeFile(fmt.Sprintf("%vpage%v", td, n), []byte("tag"))
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			cached.rebuildCache()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Rebuild never finished while writes were arriving")
+	}
+	close(stop)
+	n := <-written
+
+	if got := len(flattenWikis(cached.IndexWikiFiles("", wd))); got != n {
+		t.Errorf("Expected %v pages in the nav but got %v", n, got)
+	}
+	if got := len(cached.GetTagWikis("tag").Wikis); got != n {
+		t.Errorf("Expected %v pages tagged but got %v", n, got)
+	}
+}
+
+// TestCachedStorageExternal checks files changed behind the cache's back are
+// picked up from the names the watcher reports
+func TestCachedStorageExternal(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	wd := tmpDir + "/"
+	td := wd + "tags/"
+	os.MkdirAll(td, 0755)
+	os.WriteFile(wd+"gone.md", []byte("gone"), 0600)
+
+	fs := &fileStorage{TagDir: td}
+	cached := newCachedStorage(fs, wd, td)
+
+	os.MkdirAll(wd+"folder", 0755)
+	os.WriteFile(wd+"folder/synced.md", []byte("synced"), 0600)
+	os.Remove(wd + "gone.md")
+	cached.applyExternal([]string{wd + "folder", wd + "folder/synced.md", wd + "gone.md"})
+
+	full := navNames(fs.IndexWikiFiles("", wd))
+	got := navNames(cached.IndexWikiFiles("", wd))
+	if !reflect.DeepEqual(full, got) {
+		t.Errorf("Nav is %v but a rebuild gives %v", got, full)
+	}
+}
+
+// TestCachedStorageExternalSidecars checks tags and published markers
+// synced in on their own, after their page, are picked up
+func TestCachedStorageExternalSidecars(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := &fileStorage{TagDir: tagDir}
+	(&wikiPage{basePage: basePage{Title: "folder/page"}, Body: "synced words"}).save(fs)
+	cached := newCachedStorage(fs, wikiDir, tagDir)
+	cached.enableSearch("")
+
+	os.MkdirAll(tagDir+"folder", 0755)
+	os.WriteFile(getWikiTagsFilename("folder/page"), []byte("synced"), 0600)
+	os.MkdirAll(pubDir+"folder", 0755)
+	os.WriteFile(getWikiPubFilename("folder/page"), []byte{}, 0600)
+	cached.applyExternal([]string{getWikiTagsFilename("folder/page"), getWikiPubFilename("folder/page")})
+
+	if tag := cached.GetTagWikis("synced"); !reflect.DeepEqual(tag.Wikis, []string{"folder/page"}) {
+		t.Errorf("Expected the synced tag in the tag index but got %+v", tag)
+	}
+	if doc := cached.search.Docs["folder/page"]; doc == nil || !doc.Published || !reflect.DeepEqual(doc.Tags, []string{"synced"}) {
+		t.Errorf("Expected the search index to follow the sidecars but got %+v", doc)
+	}
+}
+
+func TestCachedStorageAliases(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := &fileStorage{TagDir: tagDir}
+	(&wikiPage{basePage: basePage{Title: "first"}, Body: "body"}).save(fs)
+	movePage(fs, "first", "second", false)
+
+	cached := newCachedStorage(fs, wikiDir, tagDir)
+	if aliases := cached.getAliases("second"); !reflect.DeepEqual(aliases, []string{"first"}) {
+		t.Errorf("Expected the alias left by the move but got %v", aliases)
+	}
+
+	if err := setAliases(&cached, "second", []string{"folder/other", "zed"}); err != nil {
+		t.Fatal(err)
+	}
+	if aliases := cached.getAliases("second"); !reflect.DeepEqual(aliases, []string{"folder/other", "zed"}) {
+		t.Errorf("Expected the aliases just set but got %v", aliases)
+	}
+	if _, err := movePage(&cached, "second", "third", false); err != nil {
+		t.Fatal(err)
+	}
+	if aliases := cached.getAliases("third"); !reflect.DeepEqual(aliases, []string{"folder/other", "second", "zed"}) {
+		t.Errorf("Expected the aliases to follow the move but got %v", aliases)
+	}
+	if aliases := cached.getAliases("second"); len(aliases) != 0 {
+		t.Errorf("Expected nothing left pointing at the old name but got %v", aliases)
+	}
+	if aliases := fs.getAliases("third"); !reflect.DeepEqual(aliases, cached.getAliases("third")) {
+		t.Errorf("Expected the cache to match the files but got %v", aliases)
+	}
+}
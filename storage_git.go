@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitStorage wraps fileStorage and records every change made through it as a
+// commit in a git repository rooted at the wiki folder.  If a remote is set
+// each commit is pushed to it as well.
+type gitStorage struct {
+	fileStorage
+	dir    string
+	remote string
+	mu     sync.Mutex
+}
+
+// newGitStorage makes sure dir is a git repository and returns a storage that
+// commits to it
+func newGitStorage(fs fileStorage, dir, remote string) (*gitStorage, error) {
+	gs := &gitStorage{fileStorage: fs, dir: strings.TrimSuffix(dir, "/") + "/", remote: remote}
+
+	if err := os.MkdirAll(gs.dir, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(gs.dir, ".git")); os.IsNotExist(err) {
+		if _, err := gs.git("init"); err != nil {
+			return nil, err
+		}
+		// Page history is already covered by git, the search index can be
+		// rebuilt and drafts are scratch copies so keep them out of the repo
+		exclude := filepath.Join(gs.dir, ".git", "info", "exclude")
+		if err := createDir(exclude); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(exclude, []byte("history/\n.index/\ndrafts/\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	// Commits fail without an identity so give the repo one if git has none
+	if _, err := gs.git("config", "user.email"); err != nil {
+		if _, err := gs.git("config", "user.name", "wiki"); err != nil {
+			return nil, err
+		}
+		if _, err := gs.git("config", "user.email", "wiki@localhost"); err != nil {
+			return nil, err
+		}
+	}
+	return gs, nil
+}
+
+func (gs *gitStorage) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = gs.dir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("git %v: %v: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
+
+// relPath turns a full file name into one relative to the repo
+func (gs *gitStorage) relPath(name string) string {
+	if rel, err := filepath.Rel(gs.dir, name); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return name
+}
+
+// describe gives a short human readable description of a file in the wiki
+// folder, e.g. "Projects/Foo" for the page or "tags Projects/Foo" for its tags
+func describe(rel string) string {
+	for _, dir := range []string{"tags", "pub", "history", "aliases", "trash", "meta"} {
+		if strings.HasPrefix(rel, dir+"/") {
+			return dir + " " + strings.TrimPrefix(rel, dir+"/")
+		}
+	}
+	return strings.TrimSuffix(rel, ".md")
+}
+
+// commit stages the given files (including removals) and commits them.  It
+// is not an error for there to be nothing to commit, e.g. saving a page
+// without changing it.
+func (gs *gitStorage) commit(message string, names ...string) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	args := []string{"add", "-A", "--"}
+	for _, n := range names {
+		args = append(args, gs.relPath(n))
+	}
+	if _, err := gs.git(args...); err != nil {
+		return err
+	}
+	if _, err := gs.git("diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	if _, err := gs.git("commit", "-q", "-m", message); err != nil {
+		return err
+	}
+	log.Printf("[git] %v", message)
+
+	if gs.remote != "" {
+		// A failed push shouldn't lose the save, it will go with the next one
+		if out, err := gs.git("push", "-q", gs.remote, "HEAD"); err != nil {
+			log.Printf("[git] push failed: %v %v", err, out)
+		}
+	}
+	return nil
+}
+
+func (gs *gitStorage) storeFile(name string, content []byte) error {
+	if err := gs.fileStorage.storeFile(name, content); err != nil {
+		return err
+	}
+	return gs.commit("save "+describe(gs.relPath(name)), name)
+}
+
+func (gs *gitStorage) deleteFile(name string) error {
+	if err := gs.fileStorage.deleteFile(name); err != nil {
+		return err
+	}
+	return gs.commit("delete "+describe(gs.relPath(name)), name)
+}
+
+func (gs *gitStorage) moveFile(from, to string) error {
+	if err := gs.fileStorage.moveFile(from, to); err != nil {
+		return err
+	}
+	return gs.commit("move "+describe(gs.relPath(from))+" -> "+describe(gs.relPath(to)), from, to)
+}
+
+// imageFile maps an image URL handed back to the client onto the file
+func (gs *gitStorage) imageFile(url string) string {
+	return gs.dir + strings.TrimPrefix(url, "/wiki/raw/")
+}
+
+func (gs *gitStorage) storeImage(wikiTitle string, imageData []byte, extension string) (string, error) {
+	url, err := gs.fileStorage.storeImage(wikiTitle, imageData, extension)
+	if err != nil {
+		return url, err
+	}
+	return url, gs.commit("add image to "+wikiTitle, gs.imageFile(url))
+}
+
+func (gs *gitStorage) storeResizedImage(wikiTitle string, imageData []byte, extension string, width, height int) (string, error) {
+	url, err := gs.fileStorage.storeResizedImage(wikiTitle, imageData, extension, width, height)
+	if err != nil {
+		return url, err
+	}
+	return url, gs.commit("add resized image to "+wikiTitle, gs.imageFile(url))
+}
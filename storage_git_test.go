@@ -0,0 +1,209 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gitLog(t *testing.T, gitDir string) []string {
+	out, err := exec.Command("git", "--git-dir", gitDir, "log", "--format=%s").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git log failed: %v %s", err, out)
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
+
+func TestGitStorage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	remote := filepath.Join(tmpDir, "remote.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v %s", err, out)
+	}
+
+	originalWikiDir := wikiDir
+	wikiDir = filepath.Join(tmpDir, "wiki") + "/"
+	defer func() { wikiDir = originalWikiDir }()
+
+	gs, err := newGitStorage(fileStorage{TagDir: wikiDir + "tags/"}, wikiDir, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gs.storeFile(wikiDir+"Projects/Foo.md", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// Saving the same content again has nothing to commit
+	if err := gs.storeFile(wikiDir+"Projects/Foo.md", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.storeFile(wikiDir+"tags/Projects/Foo", []byte("one,two")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.moveFile(wikiDir+"Projects/Foo.md", wikiDir+"Projects/Bar.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gs.storeImage("Projects/Bar", []byte("not really a png"), ".png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.deleteFile(wikiDir + "Projects/Bar.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"delete Projects/Bar",
+		"add image to Projects/Bar",
+		"move Projects/Foo -> Projects/Bar",
+		"save tags Projects/Foo",
+		"save Projects/Foo",
+	}
+	local := gitLog(t, filepath.Join(wikiDir, ".git"))
+	if strings.Join(local, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected commits %v but got %v", expected, local)
+	}
+
+	pushed := gitLog(t, remote)
+	if len(pushed) != len(expected) {
+		t.Errorf("Expected %v commits pushed to the remote but got %v", len(expected), pushed)
+	}
+
+	out, err := exec.Command("git", "-C", wikiDir, "status", "--porcelain").CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Errorf("Expected a clean work tree but got %s", out)
+	}
+}
+
+func TestGitStorageUnderCache(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	wd := tmpDir + "/"
+	td := wd + "tags/"
+	os.MkdirAll(td, 0755)
+
+	gs, err := newGitStorage(fileStorage{TagDir: td}, wd, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := newCachedStorage(gs, wd, td)
+
+	if err := cached.storeFile(wd+"page.md", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	log := gitLog(t, filepath.Join(wd, ".git"))
+	if log[0] != "save page" {
+		t.Errorf("Expected the cached write to be committed but got %v", log)
+	}
+
+	// Let the async cache rebuild finish before the folder goes
+	cached.rebuilds.Wait()
+}
+
+func TestGitStorageMovePage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	defer useTempWiki(t)()
+	gs, err := newGitStorage(fileStorage{TagDir: tagDir}, wikiDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{"first", "second"} {
+		if err := (&wikiPage{basePage: basePage{Title: "a"}, Body: template.HTML(body), Tags: "x"}).save(gs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := movePage(gs, "a", "folder/b", false); err != nil {
+		t.Fatal(err)
+	}
+	if to := gs.getAlias("a"); to != "folder/b" {
+		t.Errorf("Expected an alias to the new name but got %q", to)
+	}
+	if revs := gs.getRevisions("folder/b"); len(revs) != 1 {
+		t.Errorf("Expected the history to move with the page but got %v", revs)
+	}
+
+	out, err := exec.Command("git", "-C", wikiDir, "status", "--porcelain").CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Errorf("Expected the move to be committed but got %s", out)
+	}
+}
+
+func TestGitStorageTrash(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	defer useTempWiki(t)()
+	gs, err := newGitStorage(fileStorage{TagDir: tagDir}, wikiDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{"first", "second"} {
+		if err := (&wikiPage{basePage: basePage{Title: "a"}, Body: template.HTML(body)}).save(gs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setAliases(gs, "a", []string{"other"})
+
+	item, err := moveToTrash(gs, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := listTrash(); len(items) != 1 || !contains("history", items[0].Items) {
+		t.Errorf("Expected the page and its history in the trash but got %+v", items)
+	}
+	if to := gs.getAlias("other"); to != "" {
+		t.Errorf("Expected the alias to go with the page but got %q", to)
+	}
+
+	if _, err := restoreTrash(gs, item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if revs := gs.getRevisions("a"); len(revs) != 1 || gs.getAlias("other") != "a" {
+		t.Errorf("Expected the history and alias back but got %v and %q", revs, gs.getAlias("other"))
+	}
+
+	item, err = moveToTrash(gs, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := purgeTrash(gs, item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(getTrashDir(item.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected the trash folder to be gone")
+	}
+
+	out, err := exec.Command("git", "-C", wikiDir, "status", "--porcelain").CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Errorf("Expected everything to be committed but got %s", out)
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "os"
+
 type stubStorage struct {
 	page                wikiPage
 	expectederr         error
@@ -8,6 +10,10 @@ type stubStorage struct {
 	storeFileFunc       func(string, []byte) error
 	storeImageFunc      func(string, []byte, string) (string, error)
 	storeResizedImageFunc func(string, []byte, string, int, int) (string, error)
+	getRevisionFunc     func(string, string) (revision, error)
+	queryPagesFunc      func(string) ([]QueryResults, error)
+	getBacklinksFunc    func(string) []string
+	getAliasFunc        func(string) string
 	loggerFunc          func(string)
 }
 
@@ -38,13 +44,50 @@ func (ss *stubStorage) getPublicPages() []string {
 }
 
 func (ss *stubStorage) getPage(p *wikiPage) (*wikiPage, error) {
-	return ss.getPageFunc(p)
+	if ss.getPageFunc != nil {
+		return ss.getPageFunc(p)
+	}
+	return p, os.ErrNotExist
 }
 
 func (ss *stubStorage) searchPages(root, query string) []string {
 	return []string{}
 }
 
+func (ss *stubStorage) queryPages(query string, offset, limit int) ([]QueryResults, int, error) {
+	if ss.queryPagesFunc != nil {
+		res, err := ss.queryPagesFunc(query)
+		return resultWindow(res, offset, limit), len(res), err
+	}
+	return []QueryResults{}, 0, nil
+}
+
+func (ss *stubStorage) getLinks(title string) []string {
+	return []string{}
+}
+
+func (ss *stubStorage) getBacklinks(title string) []string {
+	if ss.getBacklinksFunc != nil {
+		return ss.getBacklinksFunc(title)
+	}
+	return []string{}
+}
+
+func (ss *stubStorage) hasLinkIndex() bool {
+	return ss.getBacklinksFunc != nil
+}
+
+func (ss *stubStorage) getAlias(name string) string {
+	if ss.getAliasFunc != nil {
+		return ss.getAliasFunc(name)
+	}
+	return ""
+}
+
+func (ss *stubStorage) getAliases(title string) []string {
+	return []string{}
+}
+
 func (ss *stubStorage) checkForPDF(p *wikiPage) (*wikiPage, error) {
 	return &ss.page, ss.expectederr
 }
@@ -76,3 +119,16 @@ func (ss *stubStorage) storeResizedImage(wikiTitle string, imageData []byte, ext
 	ss.logit("storeResizedImage")
 	return "/wiki/raw/images/" + wikiTitle + "/test_resized.png", nil
 }
+
+func (ss *stubStorage) storeRevision(title string) error {
+	ss.logit("storeRevision")
+	return nil
+}
+
+func (ss *stubStorage) getRevisions(title string) []revision {
+	return []revision{}
+}
+
+func (ss *stubStorage) getRevision(title, id string) (revision, error) {
+	return ss.getRevisionFunc(title, id)
+}
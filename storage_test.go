@@ -879,6 +879,27 @@ func (m *mockFileSystem) searchPages(root, query string) []string {
 	return results
 }
 
+func (m *mockFileSystem) queryPages(query string, offset, limit int) ([]QueryResults, int, error) {
+	res := ParseQueryResults(m.searchPages("", query))
+	return resultWindow(res, offset, limit), len(res), nil
+}
+
+func (m *mockFileSystem) getLinks(title string) []string {
+	return []string{}
+}
+
+func (m *mockFileSystem) getBacklinks(title string) []string {
+	return []string{}
+}
+
+func (m *mockFileSystem) getAlias(name string) string {
+	return ""
+}
+
+func (m *mockFileSystem) getAliases(title string) []string {
+	return []string{}
+}
+
 func (m *mockFileSystem) checkForPDF(p *wikiPage) (*wikiPage, error) {
 	return p, nil
 }
@@ -907,4 +928,15 @@ func (m *mockFileSystem) getWikiList(from string) []string {
 		}
 	}
 	return list
-}
\ No newline at end of file
+}
+func (m *mockFileSystem) storeRevision(title string) error {
+	return nil
+}
+
+func (m *mockFileSystem) getRevisions(title string) []revision {
+	return []revision{}
+}
+
+func (m *mockFileSystem) getRevision(title, id string) (revision, error) {
+	return revision{}, errors.New("revision not found")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// summaryLength is roughly how many characters a page summary runs to
+const summaryLength = 200
+
+// encryptedSummary stands in for the summary of an encrypted page so
+// nothing from it ends up in the menu or search results
+const encryptedSummary = "Encrypted page"
+
+// Markdown that is replaced by its text, or dropped, in summaries
+var summaryStrip = []struct {
+	re   *regexp.Regexp
+	with string
+}{
+	{regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`), ""},
+	{regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`), "$1"},
+	{regexp.MustCompile(`\[([^\]]*)\]\[[^\]]*\]`), "$1"},
+	{regexp.MustCompile(`\{\{!?\s*([^\}#]*?)\s*(#[^\}]*)?\}\}`), "$1"},
+	{regexp.MustCompile(`<[^>]+>`), ""},
+	{regexp.MustCompile(`\*\*([^*]+)\*\*`), "$1"},
+	{regexp.MustCompile(`__([^_]+)__`), "$1"},
+	{regexp.MustCompile(`\*([^*\s][^*]*)\*`), "$1"},
+	{regexp.MustCompile(`\b_([^_]+)_\b`), "$1"},
+	{regexp.MustCompile(`~~([^~]+)~~`), "$1"},
+	{regexp.MustCompile("`([^`]+)`"), "$1"},
+}
+
+var listMarker = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+(?:\[[ xX]\]\s+)?`)
+var quoteMarker = regexp.MustCompile(`^\s*(?:>\s?)+`)
+var ruleLine = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,}|=+)$`)
+
+// summarize gives a short plain text summary of a page, either the
+// description from its front matter or its first paragraph of text
+func summarize(meta map[string]interface{}, body string) string {
+	if d, ok := meta["description"].(string); ok && strings.TrimSpace(d) != "" {
+		return truncateSummary(stripMarkdown(d))
+	}
+	return truncateSummary(firstParagraph(body))
+}
+
+// pageSummary summarizes a page that has been read through the storage
+func pageSummary(p *wikiPage) string {
+	if p.Encrypted {
+		return encryptedSummary
+	}
+	return summarize(p.Meta, string(p.Body))
+}
+
+// fileSummary summarizes a page straight from its file, never decrypting
+// it, for building the menu
+func fileSummary(filename string) string {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	if bytes.HasPrefix(raw, encryptionFlag) {
+		return encryptedSummary
+	}
+	fm, body := splitFrontMatter(raw)
+	var meta map[string]interface{}
+	if fm != nil {
+		meta = fm.Meta
+	}
+	return summarize(meta, string(body))
+}
+
+// firstParagraph finds the first run of lines with some text in them,
+// skipping headings, rules, code blocks and paragraphs that are only
+// markup such as an image
+func firstParagraph(body string) string {
+	lines := splitLines(body)
+	var para []string
+	fence := ""
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		end := false
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+			end = true
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || ruleLine.MatchString(trimmed) || strings.HasPrefix(trimmed, "|"):
+			end = true
+		case i+1 < len(lines) && len(para) == 0 && ruleLine.MatchString(strings.TrimSpace(lines[i+1])):
+			// A setext heading
+			i++
+			end = true
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			if len(para) == 0 {
+				// Indented code
+				continue
+			}
+		}
+		if end {
+			if text := stripMarkdown(strings.Join(para, "\n")); text != "" {
+				return text
+			}
+			para = nil
+			continue
+		}
+		para = append(para, line)
+	}
+	return stripMarkdown(strings.Join(para, "\n"))
+}
+
+// stripMarkdown turns a bit of markdown into plain text on one line
+func stripMarkdown(text string) string {
+	var lines []string
+	for _, line := range splitLines(text) {
+		line = quoteMarker.ReplaceAllString(line, "")
+		line = listMarker.ReplaceAllString(line, "")
+		lines = append(lines, line)
+	}
+	text = strings.Join(lines, " ")
+	for _, s := range summaryStrip {
+		text = s.re.ReplaceAllString(text, s.with)
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// truncateSummary cuts text down to about summaryLength characters,
+// breaking between words
+func truncateSummary(text string) string {
+	if utf8.RuneCountInString(text) <= summaryLength {
+		return text
+	}
+	cut := string([]rune(text)[:summaryLength])
+	if i := strings.LastIndex(cut, " "); i > summaryLength/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:-") + "…"
+}
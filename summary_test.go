@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSummarize(t *testing.T) {
+	for body, expected := range map[string]string{
+		"# Title\n\nFirst **bold** para\nwith a [link](http://x) and {{Other Page#bit}}.\n\nSecond para": "First bold para with a link and Other Page.",
+		"Setext\n======\n\n![pic](/wiki/raw/images/x.png)\n\n> quoted `code`":                         "quoted code",
+		"```\ncode first\n```\n- item _one_\n- item two":                                            "item one item two",
+		"---\n\n    indented code\n\nsnake_case_name stays":                                          "snake_case_name stays",
+		"":                                                                                           "",
+	} {
+		if s := summarize(nil, body); s != expected {
+			t.Errorf("Expected %q from %q but got %q", expected, body, s)
+		}
+	}
+
+	meta := map[string]interface{}{"description": "From the *front matter*"}
+	if s := summarize(meta, "Body text"); s != "From the front matter" {
+		t.Errorf("Expected the description but got %q", s)
+	}
+
+	long := strings.Repeat("word ", 100)
+	s := summarize(nil, long)
+	if !strings.HasSuffix(s, "word…") || utf8.RuneCountInString(s) > summaryLength+1 {
+		t.Errorf("Expected a truncated summary but got %q", s)
+	}
+}
+
+func TestNavSummaries(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	for _, p := range []wikiPage{
+		{basePage: basePage{Title: "folder/plain"}, Body: "# Heading\nSome plain words", Tags: "t"},
+		{basePage: basePage{Title: "secret"}, Body: "hidden words", Tags: "t", Encrypted: true},
+	} {
+		if err := p.save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(wikiDir+"notes.txt", []byte("A text file"), 0644)
+
+	n := getNav(&fs)
+	for title, expected := range map[string]string{
+		"folder/plain": "Some plain words",
+		"secret":       encryptedSummary,
+		"notes":        "A text file",
+	} {
+		if n.Summaries[title] != expected {
+			t.Errorf("Expected %v to have summary %q but got %q", title, expected, n.Summaries[title])
+		}
+	}
+
+	res, _, err := fs.queryPages("words", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.WikiName == "secret" && r.Summary != encryptedSummary {
+			t.Errorf("Expected the placeholder for an encrypted page but got %q", r.Summary)
+		}
+		if r.WikiName == "folder/plain" && r.Summary != "Some plain words" {
+			t.Errorf("Expected the summary in search results but got %q", r.Summary)
+		}
+	}
+}
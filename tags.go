@@ -1,6 +1,9 @@
 package main
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
 // Tag used to store a tag and associated wiki titles
 type Tag struct {
@@ -51,3 +54,43 @@ func (t TagIndex) AssociateTagToWiki(wiki, tag string) {
 func (t TagIndex) GetTag(tag string) Tag {
 	return t[tag]
 }
+
+// Clone makes a deep copy of the index so it can be changed without
+// affecting anyone still reading the original
+func (t TagIndex) Clone() TagIndex {
+	clone := make(TagIndex, len(t))
+	for name, tag := range t {
+		tag.Wikis = append([]string(nil), tag.Wikis...)
+		clone[name] = tag
+	}
+	return clone
+}
+
+// RemoveWiki takes a wiki page out of every tag, dropping tags that end up
+// with no pages
+func (t TagIndex) RemoveWiki(wiki string) {
+	for name, tag := range t {
+		var wikis []string
+		for _, w := range tag.Wikis {
+			if w != wiki {
+				wikis = append(wikis, w)
+			}
+		}
+		if len(wikis) == 0 {
+			delete(t, name)
+			continue
+		}
+		tag.Wikis = wikis
+		t[name] = tag
+	}
+}
+
+// SetWikiTags replaces the tags for a wiki page keeping each tag's pages in
+// name order
+func (t TagIndex) SetWikiTags(wiki string, tags []string) {
+	t.RemoveWiki(wiki)
+	for _, tag := range tags {
+		t.AssociateTagToWiki(wiki, tag)
+		sort.Strings(t[strings.TrimSpace(tag)].Wikis)
+	}
+}
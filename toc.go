@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	bf "github.com/russross/blackfriday/v2"
+)
+
+// tocMarker on a line of its own puts the table of contents in the page
+const tocMarker = "[TOC]"
+
+// tocEntry is a heading in a page's table of contents along with the
+// headings below it
+type tocEntry struct {
+	Text     string
+	ID       string
+	Level    int
+	Children []*tocEntry
+}
+
+// buildTOC nests the headings in a parsed page by level.  A heading goes
+// under the nearest heading before it with a lower level, so skipping a
+// level doesn't leave gaps.  Repeated ids are made unique the way the
+// HTML renderer does it.
+func buildTOC(doc *bf.Node) []*tocEntry {
+	var toc []*tocEntry
+	var open []*tocEntry
+	ids := map[string]int{}
+	doc.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if !entering || node.Type != bf.Heading || node.IsTitleblock {
+			return bf.GoToNext
+		}
+		entry := &tocEntry{Text: nodeText(node), ID: node.HeadingID, Level: node.Level}
+		if entry.ID != "" {
+			entry.ID = uniqueHeadingID(ids, entry.ID)
+		}
+		for len(open) > 0 && open[len(open)-1].Level >= entry.Level {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			toc = append(toc, entry)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		open = append(open, entry)
+		return bf.SkipChildren
+	})
+	return toc
+}
+
+// nodeText is the plain text inside a node
+func nodeText(node *bf.Node) string {
+	var text strings.Builder
+	node.Walk(func(n *bf.Node, entering bool) bf.WalkStatus {
+		if entering && (n.Type == bf.Text || n.Type == bf.Code) {
+			text.Write(n.Literal)
+		}
+		return bf.GoToNext
+	})
+	return strings.TrimSpace(text.String())
+}
+
+// isTOCMarker checks for a paragraph that is only the marker
+func isTOCMarker(node *bf.Node) bool {
+	return node.Type == bf.Paragraph &&
+		node.FirstChild != nil && node.FirstChild == node.LastChild &&
+		node.FirstChild.Type == bf.Text &&
+		strings.TrimSpace(string(node.FirstChild.Literal)) == tocMarker
+}
+
+// tocRenderer renders markdown as blackfriday's HTML renderer does except
+// that a marker paragraph becomes the table of contents
+type tocRenderer struct {
+	*bf.HTMLRenderer
+	toc   []*tocEntry
+	found bool
+}
+
+func (r *tocRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.WalkStatus {
+	if !isTOCMarker(node) {
+		return r.HTMLRenderer.RenderNode(w, node, entering)
+	}
+	if entering {
+		r.found = true
+		if err := templates.ExecuteTemplate(w, "toc", r.toc); err != nil {
+			return bf.Terminate
+		}
+	}
+	return bf.SkipChildren
+}
+
+// parseMarkdown parses a page ready to be rendered with the renderer
+func parseMarkdown(input []byte) (*tocRenderer, *bf.Node) {
+	r := &tocRenderer{HTMLRenderer: bf.NewHTMLRenderer(bf.HTMLRendererParameters{Flags: bf.CommonHTMLFlags})}
+	doc := bf.New(bf.WithRenderer(r), bf.WithExtensions(markdownExtensions)).Parse(input)
+	r.toc = buildTOC(doc)
+	return r, doc
+}
+
+// renderMarkdown turns a markdown page into HTML, giving back the page's
+// table of contents and whether the page placed it with the marker
+func renderMarkdown(input []byte) ([]byte, []*tocEntry, bool) {
+	r, doc := parseMarkdown(input)
+
+	var buf bytes.Buffer
+	r.RenderHeader(&buf, doc)
+	doc.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		return r.RenderNode(&buf, node, entering)
+	})
+	r.RenderFooter(&buf, doc)
+	return buf.Bytes(), r.toc, r.found
+}
+
+// ShowTOC is whether a page gets a table of contents above it, which is
+// when it has a few headings and hasn't placed one itself
+func (p wikiPage) ShowTOC() bool {
+	if p.InlineTOC || len(p.Index) == 0 {
+		return false
+	}
+	return len(p.Index) > 1 || len(p.Index[0].Children) > 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConvertMarkdownTOC(t *testing.T) {
+	body := "# Intro\n\nText\n\n## First `part`\n\n#### Deep\n\n## Second\n\n```\n# not a heading\n```\n\n# Intro\n"
+	p, err := convertMarkdown(&wikiPage{Body: template.HTML(body)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Index) != 2 || p.Index[0].ID != "intro" || p.Index[1].ID != "intro-1" {
+		t.Fatalf("Expected two top level headings but got %+v", p.Index)
+	}
+	children := p.Index[0].Children
+	if len(children) != 2 || children[0].Text != "First part" || children[0].ID != "first-part" || children[1].Text != "Second" {
+		t.Errorf("Expected the second level headings under the first but got %+v", children)
+	}
+	if len(children[0].Children) != 1 || children[0].Children[0].Text != "Deep" {
+		t.Errorf("Expected a skipped level to nest under the nearest heading but got %+v", children[0].Children)
+	}
+	if p.InlineTOC || !p.ShowTOC() {
+		t.Errorf("Expected the contents above the page")
+	}
+
+	p, _ = convertMarkdown(&wikiPage{Body: "Before\n\n[TOC]\n\n# One\n\n## Two\n"}, nil)
+	html := string(p.Body)
+	if !p.InlineTOC || p.ShowTOC() {
+		t.Errorf("Expected the contents to be placed by the marker")
+	}
+	if strings.Contains(html, tocMarker) || !strings.Contains(html, `<div class="toc">`) || !strings.Contains(html, `href="#two"`) {
+		t.Errorf("Expected the marker replaced by the contents but got %v", html)
+	}
+	if strings.Index(html, "Before") > strings.Index(html, `class="toc"`) {
+		t.Errorf("Expected the contents where the marker was but got %v", html)
+	}
+
+	p, _ = convertMarkdown(&wikiPage{Body: "# Only one\n\nSee [TOC] here"}, nil)
+	if p.InlineTOC || p.ShowTOC() || !strings.Contains(string(p.Body), "See [TOC] here") {
+		t.Errorf("Expected no contents for a single heading or a marker mid paragraph but got %v", p.Body)
+	}
+}
+
+func TestViewShowsTOC(t *testing.T) {
+	s := &stubStorage{
+		getPageFunc: func(p *wikiPage) (*wikiPage, error) {
+			p.Body = "# One\n\n## Two\n"
+			p.Published = true
+			return p, nil
+		},
+	}
+	for name, handler := range map[string]func() *httptest.ResponseRecorder{
+		"view": func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/page", nil), &wikiPage{basePage: basePage{Title: "page"}}, s)
+			return w
+		},
+		"pub": func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			pubHandler(w, httptest.NewRequest("GET", "http://localhost/pub/page", nil), &wikiPage{basePage: basePage{Title: "page"}}, s)
+			return w
+		},
+	} {
+		w := handler()
+		if !strings.Contains(w.Body.String(), `href="#two"`) {
+			t.Errorf("Expected the contents on the %v page but got %v", name, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	bf "github.com/russross/blackfriday/v2"
+)
+
+// maxTranscludeDepth is how deep pages included in pages can go
+const maxTranscludeDepth = 5
+
+// transcludeRe matches {{!Page}} and {{!Page#heading}} in rendered HTML,
+// along with the paragraph around it when it is on a line of its own
+var transcludeRe = regexp.MustCompile(`<p>\{\{!\s*([^\}#]+?)\s*(?:#([^\}]*))?\}\}</p>|\{\{!\s*([^\}#]+?)\s*(?:#([^\}]*))?\}\}`)
+
+// codeRe matches code blocks and inline code in rendered HTML, where
+// markers are shown as written.  Once sanitised the closing tag can't turn
+// up inside the code itself.
+var codeRe = regexp.MustCompile(`(?s)<pre[ >].*?</pre>|<code[ >].*?</code>`)
+
+// transclude replaces the {{!Page}} markers in a rendered page with the
+// rendered content of those pages, or just the section under the heading
+// given.  stack is the pages being rendered, starting with the page
+// itself, so a page that includes itself is caught.  Published pages only
+// take in other published pages that aren't encrypted.  Markers in code
+// are left alone.
+func transclude(s storage, body []byte, published bool, stack []string) []byte {
+	return outsideCode(body, func(html []byte) []byte {
+		return transcludeMarkers(s, html, published, stack)
+	})
+}
+
+// outsideCode runs fn over the parts of rendered HTML that aren't code,
+// leaving the code as it is
+func outsideCode(body []byte, fn func([]byte) []byte) []byte {
+	var out []byte
+	last := 0
+	for _, loc := range codeRe.FindAllIndex(body, -1) {
+		out = append(out, fn(body[last:loc[0]])...)
+		out = append(out, body[loc[0]:loc[1]]...)
+		last = loc[1]
+	}
+	return append(out, fn(body[last:])...)
+}
+
+// transcludeMarkers replaces the markers in HTML that has no code in it
+func transcludeMarkers(s storage, body []byte, published bool, stack []string) []byte {
+	return transcludeRe.ReplaceAllFunc(body, func(marker []byte) []byte {
+		m := transcludeRe.FindSubmatch(marker)
+		title, fragment := string(m[1]), string(m[2])
+		if len(m[3]) > 0 {
+			title, fragment = string(m[3]), string(m[4])
+		}
+
+		content, err := transcludePage(s, title, fragment, published, stack)
+		if err != nil {
+			return []byte(fmt.Sprintf(`<div class="transclusion-error">%s</div>`, html.EscapeString(err.Error())))
+		}
+		return []byte(fmt.Sprintf(`<div class="transclusion">%s</div>`, content))
+	})
+}
+
+// transcludePage renders a page, or a section of it, for including in
+// another
+func transcludePage(s storage, title, fragment string, published bool, stack []string) ([]byte, error) {
+	if len(stack) > maxTranscludeDepth {
+		return nil, fmt.Errorf("Can't include %v, pages are included too deeply", title)
+	}
+	p, err := s.getPage(&wikiPage{basePage: basePage{Title: title}})
+	if err != nil {
+		to := s.getAlias(title)
+		if to == "" {
+			return nil, fmt.Errorf("Can't include %v, there's no such page", title)
+		}
+		if p, err = s.getPage(&wikiPage{basePage: basePage{Title: to}}); err != nil {
+			return nil, fmt.Errorf("Can't include %v, there's no such page", title)
+		}
+	}
+	if contains(p.Title, stack) {
+		return nil, fmt.Errorf("Can't include %v, it includes this page", title)
+	}
+	if published && (p.Encrypted || !p.Published) {
+		return nil, fmt.Errorf("Can't include %v, it isn't published", title)
+	}
+
+	body := []byte(strings.ReplaceAll(string(p.Body), "\r\n", "\n"))
+	var unsafe []byte
+	if fragment == "" {
+		unsafe, _, _ = renderMarkdown(body)
+	} else {
+		var found bool
+		if unsafe, found = renderSection(body, fragment); !found {
+			return nil, fmt.Errorf("Can't include %v, it has no heading %v", title, fragment)
+		}
+	}
+	content := markdownPolicy().SanitizeBytes(unsafe)
+	return transclude(s, content, published, append(stack, p.Title)), nil
+}
+
+// renderSection renders the part of a page from the heading a fragment
+// names, either as an id or as written, up to the next heading at the same
+// level or above
+func renderSection(input []byte, fragment string) ([]byte, bool) {
+	r, doc := parseMarkdown(input)
+
+	ids := map[string]int{}
+	want := bf.SanitizedAnchorName(fragment)
+	var start *bf.Node
+	for n := doc.FirstChild; n != nil && start == nil; n = n.Next {
+		if n.Type != bf.Heading || n.HeadingID == "" {
+			continue
+		}
+		if id := uniqueHeadingID(ids, n.HeadingID); id == fragment || id == want {
+			start = n
+		}
+	}
+	if start == nil {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	for n := start; n != nil; n = n.Next {
+		if n != start && n.Type == bf.Heading && n.Level <= start.Level {
+			break
+		}
+		n.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+			return r.RenderNode(&buf, node, entering)
+		})
+	}
+	return buf.Bytes(), true
+}
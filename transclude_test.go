@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransclude(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	for _, p := range []wikiPage{
+		{basePage: basePage{Title: "host"}, Body: "Intro\n\n{{!folder/shared}}\n\nAnd {{!folder/shared#Second part}} inline", Published: true},
+		{basePage: basePage{Title: "folder/shared"}, Body: "# First part\n\nFirst *words*\n\n## Second part\n\nSecond words\n\n### Deeper\n\nDeep words\n\n## Third part\n\nThird words", Published: true},
+		{basePage: basePage{Title: "loop/a"}, Body: "A then {{!loop/b}}"},
+		{basePage: basePage{Title: "loop/b"}, Body: "B then {{!loop/a}}"},
+		{basePage: basePage{Title: "private"}, Body: "Private words"},
+		{basePage: basePage{Title: "secret"}, Body: "Secret words", Published: true, Encrypted: true},
+		{basePage: basePage{Title: "public"}, Body: "{{!private}} {{!secret}} {{!folder/shared#Third part}}", Published: true},
+		{basePage: basePage{Title: "broken"}, Body: "{{!nowhere}} {{!folder/shared#No such}}"},
+		{basePage: basePage{Title: "docs"}, Body: "Write `{{!Page}}` to include a page, e.g.\n\n```\n{{!folder/shared}}\n```\n\n    {{!private}}\n\nBut {{!private}} here"},
+	} {
+		if err := p.save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i <= maxTranscludeDepth; i++ {
+		(&wikiPage{basePage: basePage{Title: "deep/" + string(rune('a'+i))}, Body: template.HTML("level {{!deep/" + string(rune('a'+i+1)) + "}}")}).save(&fs)
+	}
+
+	render := func(title string, published bool) string {
+		p, err := convertMarkdown(fs.getPage(&wikiPage{basePage: basePage{Title: title}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(transclude(&fs, []byte(p.Body), published, []string{title}))
+	}
+
+	out := render("host", false)
+	if !strings.Contains(out, `<div class="transclusion"><h1 id="first-part">First part</h1>`) || !strings.Contains(out, "<em>words</em>") || strings.Contains(out, "<p>{{!") {
+		t.Errorf("Expected the whole page in place of the paragraph but got %v", out)
+	}
+	section := out[strings.LastIndex(out, `<div class="transclusion">`):]
+	if !strings.Contains(section, "Second words") || !strings.Contains(section, "Deep words") || strings.Contains(section, "Third words") || strings.Contains(section, "First words") {
+		t.Errorf("Expected just the second section but got %v", section)
+	}
+
+	if out := render("loop/a", false); strings.Count(out, "A then") != 1 || strings.Count(out, "B then") != 1 || !strings.Contains(out, "includes this page") {
+		t.Errorf("Expected the loop to be stopped but got %v", out)
+	}
+	if out := render("deep/a", false); !strings.Contains(out, "too deeply") || strings.Count(out, "level") != maxTranscludeDepth+1 {
+		t.Errorf("Expected the depth to be limited but got %v", out)
+	}
+	if out := render("broken", false); !strings.Contains(out, "no such page") || !strings.Contains(out, "no heading No such") {
+		t.Errorf("Expected errors for missing pages and headings but got %v", out)
+	}
+
+	out = render("docs", false)
+	if !strings.Contains(out, "<code>{{!Page}}</code>") || !strings.Contains(out, "<code>{{!folder/shared}}\n</code>") || !strings.Contains(out, "<code>{{!private}}\n</code>") || strings.Contains(out, "no such page") || strings.Count(out, "Private words") != 1 {
+		t.Errorf("Expected markers in code to be left as written but got %v", out)
+	}
+	docs := renderPage(&fs, &wikiPage{basePage: basePage{Title: "docs"}, Body: "Write `{{!Page}}` or `{{Page}}` to use a page, e.g.\n\n```\n{{!folder/shared}}\n```\n\nBut {{!private}} and {{folder/shared}} here"})
+	if out := string(docs.Body); !strings.Contains(out, "<code>{{!Page}}</code>") || !strings.Contains(out, "<code>{{Page}}</code>") || !strings.Contains(out, "<code>{{!folder/shared}}\n</code>") ||
+		strings.Contains(out, "wikilink-missing") || !strings.Contains(out, "Private words") || !strings.Contains(out, `<a href="/wiki/view/folder/shared#">folder/shared</a>`) {
+		t.Errorf("Expected the rendered page to leave code alone but got %v", out)
+	}
+
+	out = render("public", true)
+	if strings.Contains(out, "Private words") || strings.Contains(out, "Secret words") || strings.Count(out, "isn&#39;t published") != 2 || !strings.Contains(out, "Third words") {
+		t.Errorf("Expected only published pages included in a published page but got %v", out)
+	}
+	if out := render("public", false); !strings.Contains(out, "Private words") {
+		t.Errorf("Expected unpublished pages included when viewing in the wiki but got %v", out)
+	}
+
+	w := httptest.NewRecorder()
+	pubHandler(w, httptest.NewRequest("GET", "http://localhost/pub/public", nil), &wikiPage{basePage: basePage{Title: "public"}}, &fs)
+	if strings.Contains(w.Body.String(), "Private words") || !strings.Contains(w.Body.String(), "Third words") {
+		t.Errorf("Expected the published page to leave out unpublished pages but got %v", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	viewHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/view/host", nil), &wikiPage{basePage: basePage{Title: "host"}}, &fs)
+	if !strings.Contains(w.Body.String(), "Second words") || strings.Contains(w.Body.String(), "!folder") {
+		t.Errorf("Expected the included page in the view but got %v", w.Body.String())
+	}
+}
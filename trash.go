@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Deleted pages go to the trash folder rather than being removed.  Each
+// delete gets a folder, named from when it happened, holding the page's
+// files and a trash.json saying what they were.
+var trashDir string
+
+var validTrashID = regexp.MustCompile(`^[0-9]+$`)
+
+// trashItem is a page sitting in the trash
+type trashItem struct {
+	ID      string
+	Title   string
+	Deleted time.Time
+	// Items lists which of the page's files were moved, e.g. tags
+	Items []string
+	// Aliases are the other names the page had, removed while it is
+	// in the trash
+	Aliases []string `json:",omitempty"`
+}
+
+func (ti trashItem) DeletedStr() string {
+	return ti.Deleted.Format(TIME_FORMAT)
+}
+
+type trashListPage struct {
+	basePage
+	Items []trashItem
+	Error string
+}
+
+func getTrashDir(id string) string {
+	return trashDir + id + "/"
+}
+
+// moveToTrash moves a page and everything that goes with it into the
+// trash.  Its aliases are noted in the trash and removed so they don't
+// lead to a missing page.
+func moveToTrash(s storage, title string) (*trashItem, error) {
+	now := time.Now()
+	item := &trashItem{ID: strconv.FormatInt(now.UnixNano(), 10), Title: title, Deleted: now}
+	dir := getTrashDir(item.ID)
+	for i, f := range pageFiles(title) {
+		err := f.move(s, f.path, dir+f.trash)
+		if err == nil {
+			item.Items = append(item.Items, f.name)
+		} else if i == 0 {
+			return nil, err
+		} else if !os.IsNotExist(err) {
+			// Record what did make it so it can still be restored
+			writeTrashItem(s, item)
+			return item, err
+		}
+	}
+	for _, a := range s.getAliases(title) {
+		if err := s.deleteFile(getWikiAliasFilename(a)); err != nil && !os.IsNotExist(err) {
+			writeTrashItem(s, item)
+			return item, err
+		}
+		item.Aliases = append(item.Aliases, a)
+	}
+	return item, writeTrashItem(s, item)
+}
+
+func writeTrashItem(s storage, item *trashItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return s.storeFile(getTrashDir(item.ID)+"trash.json", data)
+}
+
+func getTrashItem(id string) (*trashItem, error) {
+	if !validTrashID.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(getTrashDir(id) + "trash.json")
+	if err != nil {
+		return nil, err
+	}
+	var item trashItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, err
+	}
+	item.ID = id
+	return &item, nil
+}
+
+// listTrash gives everything in the trash, most recently deleted first
+func listTrash() []trashItem {
+	items := []trashItem{}
+	entries, err := os.ReadDir(trashDir)
+	if err != nil {
+		return items
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		item, err := getTrashItem(e.Name())
+		if err != nil {
+			log.Printf("[trash] %v: %v", e.Name(), err)
+			continue
+		}
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.After(items[j].Deleted)
+	})
+	return items
+}
+
+// restoreTrash puts a page back where it was deleted from, as long as
+// nothing has been created there since
+func restoreTrash(s storage, id string) (*trashItem, error) {
+	item, err := getTrashItem(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(getWikiFilename(wikiDir, item.Title)); err == nil {
+		return item, fmt.Errorf("%v already exists, move it out of the way to restore this one", item.Title)
+	}
+
+	dir := getTrashDir(id)
+	for _, f := range pageFiles(item.Title) {
+		if !contains(f.name, item.Items) {
+			continue
+		}
+		if err := f.move(s, dir+f.trash, f.path); err != nil && !os.IsNotExist(err) {
+			return item, err
+		}
+	}
+	// Aliases only come back if nothing has taken the name since
+	for _, a := range item.Aliases {
+		if s.getAlias(a) != "" {
+			continue
+		}
+		if _, err := os.Stat(getWikiFilename(wikiDir, a)); err == nil {
+			continue
+		}
+		if err := s.storeFile(getWikiAliasFilename(a), []byte(item.Title)); err != nil {
+			return item, err
+		}
+	}
+	return item, removeTrashDir(s, id)
+}
+
+// purgeTrash deletes a page from the trash for good
+func purgeTrash(s storage, id string) error {
+	if _, err := getTrashItem(id); err != nil {
+		return err
+	}
+	return removeTrashDir(s, id)
+}
+
+// removeTrashDir deletes what's left in a trash folder.  Files go through
+// the storage so that, e.g. git, records them going, apart from the local
+// ones the storage never had.
+func removeTrashDir(s storage, id string) error {
+	dir := getTrashDir(id)
+	local := map[string]bool{}
+	for _, f := range pageFiles("") {
+		if f.local {
+			local[dir+f.trash] = true
+		}
+	}
+	var files []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if local[path] {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	for _, f := range files {
+		if err := s.deleteFile(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// purgeExpiredTrash deletes anything that has been in the trash for longer
+// than days.  Zero or less keeps everything.
+func purgeExpiredTrash(s storage, days int, now time.Time) {
+	if days <= 0 {
+		return
+	}
+	cutoff := now.AddDate(0, 0, -days)
+	for _, item := range listTrash() {
+		if !item.Deleted.Before(cutoff) {
+			continue
+		}
+		if err := purgeTrash(s, item.ID); err != nil {
+			log.Printf("[trash] failed to purge %v: %v", item.Title, err)
+			continue
+		}
+		log.Printf("[trash] purged %v deleted %v", item.Title, item.DeletedStr())
+	}
+}
+
+// keepTrashTidy purges expired pages at startup and then once a day
+func keepTrashTidy(s storage, days int) {
+	for {
+		purgeExpiredTrash(s, days, time.Now())
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// trashHandler lists the trash, or with a POST to /wiki/trash/<id>
+// restores or purges an item depending on the action
+func trashHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
+	tp := trashListPage{basePage: basePage{Title: "Trash", Nav: p.Nav}}
+	if r.Method != "POST" || p.Title == "" {
+		tp.Items = listTrash()
+		renderTemplate(w, "trash", tp)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "restore":
+		item, err := restoreTrash(s, p.Title)
+		if err != nil && item == nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			tp.Items = listTrash()
+			tp.Error = err.Error()
+			w.WriteHeader(http.StatusConflict)
+			renderTemplate(w, "trash", tp)
+			return
+		}
+		http.Redirect(w, r, "/wiki/view/"+item.Title, http.StatusFound)
+	case "purge":
+		if err := purgeTrash(s, p.Title); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, os.ErrNotExist) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		http.Redirect(w, r, "/wiki/trash/", http.StatusFound)
+	default:
+		http.Error(w, "Form param 'action' should be restore or purge", http.StatusBadRequest)
+	}
+}
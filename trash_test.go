@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func trashRequest(id, action string) *http.Request {
+	form := url.Values{}
+	form.Add("action", action)
+	req := httptest.NewRequest("POST", "http://localhost/wiki/trash/"+id, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	defer useTempWiki(t)()
+
+	fs := fileStorage{TagDir: tagDir}
+	cached := newCachedStorage(&fs, wikiDir, tagDir)
+	cached.enableSearch("")
+
+	p := wikiPage{basePage: basePage{Title: "folder/binned"}, Body: "findable words", Tags: "a", Published: true}
+	if err := p.save(&cached); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(getWikiImagesDir("folder/binned"), 0755)
+	os.WriteFile(getWikiImagesDir("folder/binned")+"/1.png", []byte("png"), 0644)
+
+	w := httptest.NewRecorder()
+	deleteHandler(w, httptest.NewRequest("POST", "http://localhost/wiki/delete/folder/binned", nil), &wikiPage{basePage: basePage{Title: "folder/binned"}}, &cached)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect after delete, got %v: %v", w.Code, w.Body.String())
+	}
+	cached.rebuilds.Wait()
+
+	for _, gone := range []string{getWikiFilename(wikiDir, "folder/binned"), getWikiTagsFilename("folder/binned"), getWikiPubFilename("folder/binned"), getWikiImagesDir("folder/binned")} {
+		if _, err := os.Stat(gone); !os.IsNotExist(err) {
+			t.Errorf("Expected %v to be in the trash", gone)
+		}
+	}
+	items := listTrash()
+	if len(items) != 1 || items[0].Title != "folder/binned" || len(items[0].Items) != 5 {
+		t.Fatalf("Expected the page and its files in the trash but got %+v", items)
+	}
+	if res, _, _ := cached.queryPages("findable", 0, 0); len(res) != 0 {
+		t.Errorf("Expected trashed pages not to be searchable but got %+v", res)
+	}
+	for _, n := range flattenWikis(cached.IndexWikiFiles("", wikiDir)) {
+		if strings.Contains(n.URL, "binned") || strings.Contains(n.URL, "trash") {
+			t.Errorf("Expected the trash to be left out of the nav but found %v", n.URL)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	trashHandler(w, httptest.NewRequest("GET", "http://localhost/wiki/trash/", nil), &wikiPage{}, &cached)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "folder/binned") {
+		t.Errorf("Expected the trash listing but got %v: %v", w.Code, w.Body.String())
+	}
+
+	// Something new in the way stops the restore
+	blocker := wikiPage{basePage: basePage{Title: "folder/binned"}, Body: "new one"}
+	blocker.save(&cached)
+	w = httptest.NewRecorder()
+	trashHandler(w, trashRequest(items[0].ID, "restore"), &wikiPage{basePage: basePage{Title: items[0].ID}}, &cached)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected a conflict restoring over an existing page, got %v", w.Code)
+	}
+	fs.deleteFile(getWikiFilename(wikiDir, "folder/binned"))
+
+	w = httptest.NewRecorder()
+	trashHandler(w, trashRequest(items[0].ID, "restore"), &wikiPage{basePage: basePage{Title: items[0].ID}}, &cached)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/wiki/view/folder/binned" {
+		t.Fatalf("Expected a redirect to the restored page, got %v: %v", w.Code, w.Body.String())
+	}
+	restored, err := cached.getPage(&wikiPage{basePage: basePage{Title: "folder/binned"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Body != "findable words" || restored.Tags != "a" || !restored.Published {
+		t.Errorf("Restored page not as expected: %+v", restored)
+	}
+	if _, err := os.Stat(getWikiImagesDir("folder/binned") + "/1.png"); err != nil {
+		t.Errorf("Expected the image to be restored: %v", err)
+	}
+	if len(listTrash()) != 0 {
+		t.Errorf("Expected the trash to be empty after restoring")
+	}
+
+	item, err := moveToTrash(&cached, "folder/binned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	trashHandler(w, trashRequest(item.ID, "purge"), &wikiPage{basePage: basePage{Title: item.ID}}, &cached)
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected a redirect after purging, got %v: %v", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(getTrashDir(item.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected the trash folder to be gone")
+	}
+
+	w = httptest.NewRecorder()
+	trashHandler(w, trashRequest("../tags", "purge"), &wikiPage{basePage: basePage{Title: "../tags"}}, &cached)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a bad id, got %v", w.Code)
+	}
+	cached.rebuilds.Wait()
+}
+
+func TestTrashAliasesAndHistory(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	p := wikiPage{basePage: basePage{Title: "binned"}, Body: "first"}
+	p.save(&fs)
+	p.Body = "second"
+	p.save(&fs)
+	setAliases(&fs, "binned", []string{"other", "taken"})
+
+	item, err := moveToTrash(&fs, "binned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains("history", item.Items) || !reflect.DeepEqual(item.Aliases, []string{"other", "taken"}) {
+		t.Errorf("Expected the history and aliases to go with the page but got %+v", item)
+	}
+	if _, err := os.Stat(getWikiHistoryDir("binned")); !os.IsNotExist(err) {
+		t.Errorf("Expected the history to be in the trash")
+	}
+	for _, a := range []string{"other", "taken"} {
+		if to := fs.getAlias(a); to != "" {
+			t.Errorf("Expected %v not to point at a trashed page but got %q", a, to)
+		}
+	}
+
+	// A name used while the page was in the trash stays as it is
+	(&wikiPage{basePage: basePage{Title: "taken"}, Body: "new page"}).save(&fs)
+	if _, err := restoreTrash(&fs, item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if aliases := fs.getAliases("binned"); !reflect.DeepEqual(aliases, []string{"other"}) {
+		t.Errorf("Expected only the free alias back but got %v", aliases)
+	}
+	if revs := fs.getRevisions("binned"); len(revs) != 1 {
+		t.Errorf("Expected the history back but got %v", revs)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	defer useTempWiki(t)()
+	fs := fileStorage{TagDir: tagDir}
+
+	for _, title := range []string{"old", "recent"} {
+		if err := (&wikiPage{basePage: basePage{Title: title}, Body: "body"}).save(&fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, err := moveToTrash(&fs, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Deleted = time.Now().AddDate(0, 0, -31)
+	writeTrashItem(&fs, old)
+	if _, err := moveToTrash(&fs, "recent"); err != nil {
+		t.Fatal(err)
+	}
+
+	purgeExpiredTrash(&fs, 0, time.Now())
+	if len(listTrash()) != 2 {
+		t.Errorf("Expected nothing purged with no retention period")
+	}
+
+	purgeExpiredTrash(&fs, 30, time.Now())
+	items := listTrash()
+	if len(items) != 1 || items[0].Title != "recent" {
+		t.Errorf("Expected only recent to be left but got %+v", items)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// wikiWatcher notices changes made to the wiki folder outside of the app, e.g.
+// files synced in by Dropbox, and once things have gone quiet for the
+// debounce period calls onChange with the files that changed.  It uses
+// inotify (or the OS equivalent) and can fall back to polling the folder for
+// filesystems where that is unreliable.
+type wikiWatcher struct {
+	root     string
+	debounce time.Duration
+	onChange func([]string)
+	changed  chan string
+	stop     chan struct{}
+	running  sync.WaitGroup
+}
+
+func newWikiWatcher(root string, debounce time.Duration, onChange func([]string)) *wikiWatcher {
+	ww := &wikiWatcher{
+		root:     root,
+		debounce: debounce,
+		onChange: onChange,
+		changed:  make(chan string, 64),
+		stop:     make(chan struct{}),
+	}
+	ww.run(ww.debounceLoop)
+	return ww
+}
+
+// start begins watching using "notify" or "poll".  If notify can't be set up
+// the watcher drops back to polling.
+func (ww *wikiWatcher) start(mode string, interval time.Duration) {
+	if mode == "notify" {
+		err := ww.watchNotify()
+		if err == nil {
+			return
+		}
+		log.Printf("[watch] notify unavailable, polling instead: %v", err)
+	}
+	ww.run(func() { ww.poll(interval) })
+}
+
+// run starts a goroutine that close waits for
+func (ww *wikiWatcher) run(fn func()) {
+	ww.running.Add(1)
+	go func() {
+		defer ww.running.Done()
+		fn()
+	}()
+}
+
+// close stops the watcher, returning once it has
+func (ww *wikiWatcher) close() {
+	close(ww.stop)
+	ww.running.Wait()
+}
+
+// relative gives a path's place in the wiki folder.  Paths from WalkDir and
+// fsnotify are cleaned so they won't always start with the root as it was
+// configured.
+func (ww *wikiWatcher) relative(path string) string {
+	rel, err := filepath.Rel(filepath.Clean(ww.root), path)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// watchedDir are the folders the wiki keeps its own data in that are still
+// watched, as sync tools can bring them in separately from their pages
+var watchedDir = []string{"tags", "pub"}
+
+// ignored skips hidden files and folders such as .git along with the
+// folders the wiki keeps its own data in, other than watchedDir
+func (ww *wikiWatcher) ignored(path string) bool {
+	rel := ww.relative(path)
+	if rel == "" {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return contains(parts[0], specialDir) && !contains(parts[0], watchedDir)
+}
+
+// notify flags that a file changed, naming it the way the rest of the wiki
+// does, i.e. starting with the root
+func (ww *wikiWatcher) notify(path string) {
+	rel := ww.relative(path)
+	if rel == "" {
+		return
+	}
+	select {
+	case ww.changed <- strings.TrimSuffix(ww.root, "/") + "/" + rel:
+	case <-ww.stop:
+	}
+}
+
+func (ww *wikiWatcher) debounceLoop() {
+	var timer <-chan time.Time
+	pending := map[string]bool{}
+	for {
+		select {
+		case <-ww.stop:
+			return
+		case name := <-ww.changed:
+			pending[name] = true
+			timer = time.After(ww.debounce)
+		case <-timer:
+			timer = nil
+			names := make([]string, 0, len(pending))
+			for name := range pending {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			pending = map[string]bool{}
+			log.Printf("[watch] wiki folder changed, %v files", len(names))
+			ww.onChange(names)
+		}
+	}
+}
+
+// watchNotify adds every folder under root to an fsnotify watcher, adding
+// new folders as they appear as inotify is not recursive
+func (ww *wikiWatcher) watchNotify() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// addTree watches the folders under root, reporting any files already
+	// in them when found is set
+	addTree := func(root string, found bool) error {
+		return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if ww.ignored(path) {
+					return filepath.SkipDir
+				}
+				return watcher.Add(path)
+			}
+			if found && !ww.ignored(path) {
+				ww.notify(path)
+			}
+			return nil
+		})
+	}
+	if err := addTree(ww.root, false); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	ww.run(func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ww.stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ww.ignored(event.Name) || event.Op == fsnotify.Chmod {
+					continue
+				}
+				if event.Op&fsnotify.Create != 0 {
+					// Files can land in a new folder before the watch on it
+					// is added so pick up whatever is already there
+					addTree(event.Name, true)
+				}
+				ww.notify(event.Name)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("[watch] %v", err)
+			}
+		}
+	})
+	return nil
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// snapshot records the mod time and size of every file under root
+func (ww *wikiWatcher) snapshot() map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	filepath.WalkDir(ww.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ww.ignored(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			stamps[path] = fileStamp{info.ModTime(), info.Size()}
+		}
+		return nil
+	})
+	return stamps
+}
+
+// changedStamps lists the files added, changed or removed between two
+// snapshots
+func changedStamps(a, b map[string]fileStamp) []string {
+	var changed []string
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !w.mod.Equal(v.mod) || w.size != v.size {
+			changed = append(changed, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	return changed
+}
+
+func (ww *wikiWatcher) poll(interval time.Duration) {
+	last := ww.snapshot()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ww.stop:
+			return
+		case <-ticker.C:
+			current := ww.snapshot()
+			for _, path := range changedStamps(last, current) {
+				ww.notify(path)
+			}
+			last = current
+		}
+	}
+}
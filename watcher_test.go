@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls until the count reaches at least n or gives up
+func waitFor(count *int32, n int32) bool {
+	for i := 0; i < 100; i++ {
+		if atomic.LoadInt32(count) >= n {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func testWatcher(t *testing.T, mode string) {
+	originalSpecialDir := specialDir
+	specialDir = []string{"tags", "pub", "history", "aliases", "trash", "meta", "drafts"}
+	defer func() { specialDir = originalSpecialDir }()
+
+	tmpDir, err := os.MkdirTemp("", "wiki-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var calls int32
+	var mu sync.Mutex
+	reported := map[string]bool{}
+	ww := newWikiWatcher(tmpDir+"/", 50*time.Millisecond, func(names []string) {
+		mu.Lock()
+		for _, name := range names {
+			reported[name] = true
+		}
+		mu.Unlock()
+		atomic.AddInt32(&calls, 1)
+	})
+	ww.start(mode, 20*time.Millisecond)
+	defer ww.close()
+
+	// A burst of changes, including in a new sub folder, is debounced
+	os.MkdirAll(filepath.Join(tmpDir, "folder"), 0755)
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		os.WriteFile(filepath.Join(tmpDir, "folder", "page.md"), []byte{byte(i)}, 0600)
+	}
+	if !waitFor(&calls, 1) {
+		t.Fatal("Watcher never reported the change")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n > 2 {
+		t.Errorf("Expected changes to be debounced but got %v calls", n)
+	}
+
+	// Files in new folders are picked up too
+	before := atomic.LoadInt32(&calls)
+	os.WriteFile(filepath.Join(tmpDir, "folder", "other.md"), []byte("x"), 0600)
+	if !waitFor(&calls, before+1) {
+		t.Error("Watcher missed a file created in a new folder")
+	}
+	mu.Lock()
+	if !reported[tmpDir+"/folder/page.md"] || !reported[tmpDir+"/folder/other.md"] {
+		t.Errorf("Expected the changed files to be named but got %v", reported)
+	}
+	mu.Unlock()
+
+	// Tags and published markers can be synced apart from their pages
+	os.MkdirAll(filepath.Join(tmpDir, "tags", "folder"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "pub", "folder"), 0755)
+	time.Sleep(150 * time.Millisecond)
+	before = atomic.LoadInt32(&calls)
+	os.WriteFile(filepath.Join(tmpDir, "tags", "folder", "page"), []byte("a,b"), 0600)
+	os.WriteFile(filepath.Join(tmpDir, "pub", "folder", "page"), []byte{}, 0600)
+	if !waitFor(&calls, before+1) {
+		t.Error("Watcher missed synced tags and published markers")
+	}
+	time.Sleep(150 * time.Millisecond)
+	mu.Lock()
+	if !reported[tmpDir+"/tags/folder/page"] || !reported[tmpDir+"/pub/folder/page"] {
+		t.Errorf("Expected the tags and published marker to be named but got %v", reported)
+	}
+	mu.Unlock()
+
+	// Special and hidden folders are ignored
+	os.MkdirAll(filepath.Join(tmpDir, ".git"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "history"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "meta"), 0755)
+	time.Sleep(150 * time.Millisecond)
+	before = atomic.LoadInt32(&calls)
+	os.WriteFile(filepath.Join(tmpDir, ".git", "index"), []byte("x"), 0600)
+	os.WriteFile(filepath.Join(tmpDir, "history", "page.json"), []byte("x"), 0600)
+	os.WriteFile(filepath.Join(tmpDir, "meta", "page"), []byte("x"), 0600)
+	time.Sleep(150 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != before {
+		t.Errorf("Expected ignored folders not to trigger a change but got %v calls", n-before)
+	}
+}
+
+func TestWatcherIgnored(t *testing.T) {
+	originalSpecialDir := specialDir
+	specialDir = []string{"tags", "pub", "history", "aliases", "trash", "meta", "drafts"}
+	defer func() { specialDir = originalSpecialDir }()
+
+	ww := &wikiWatcher{root: "./wikidir/"}
+	for path, expected := range map[string]bool{
+		"wikidir":                   false,
+		"wikidir/page.md":           false,
+		"wikidir/folder/history.md": false,
+		"wikidir/folder/meta/x.md":  false,
+		"wikidir/history":           true,
+		"wikidir/history/page/1":    true,
+		"wikidir/drafts/page":       true,
+		"wikidir/trash/1/tags":      true,
+		"wikidir/tags/folder/page":  false,
+		"wikidir/pub/page":          false,
+		"wikidir/meta/page":         true,
+		"wikidir/.git/index":        true,
+		"wikidir/folder/.hidden.md": true,
+	} {
+		if ww.ignored(path) != expected {
+			t.Errorf("Expected %v to be ignored %v", path, expected)
+		}
+	}
+}
+
+func TestWatcherNotify(t *testing.T) {
+	testWatcher(t, "notify")
+}
+
+func TestWatcherPoll(t *testing.T) {
+	testWatcher(t, "poll")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
@@ -30,19 +31,33 @@ type basePage struct {
 }
 
 type wikiPage struct {
-	Body      template.HTML
-	Tags      string
-	TagArray  []string
-	Created   string
-	Modified  string
+	Body       template.HTML
+	Tags       string
+	TagArray   []string
+	Created    string
+	Modified   string
+	CreatedBy  string
+	ModifiedBy string
+	// Author is who is saving the page now
+	Author    string `json:"-"`
 	Published bool
 	Encrypted bool
+	Version   string
 	basePage
-	Index []string
+	Index     []*tocEntry
+	InlineTOC bool
+	Section   string
+	Draft     *pageDraft
+	Journal   *journalNav
+	Backlinks []string
+	Aliases   []string
+	Meta      map[string]interface{}
 }
 
 type searchPage struct {
 	basePage
+	Query   string
+	Error   string
 	Results []QueryResults
 }
 
@@ -73,10 +88,18 @@ func getWikiPubFilename(name string) string {
 }
 
 func (p *wikiPage) save(s storage) error {
+	// Keep whatever is there now before it gets overwritten
+	if err := s.storeRevision(p.Title); err != nil {
+		return err
+	}
+
+	dates := p.stampDates(time.Now())
 	filename := getWikiFilename(wikiDir, p.Title)
-	body := []byte(p.Body)
+	body, err := joinFrontMatter(p.pageFrontMatter(s), []byte(p.Body))
+	if err != nil {
+		return err
+	}
 	if p.Encrypted {
-		var err error
 		body, err = encrypt(body, ekey)
 		if err != nil {
 			return err
@@ -86,13 +109,26 @@ func (p *wikiPage) save(s storage) error {
 	if err := s.storeFile(filename, body); err != nil {
 		return err
 	}
+	if err := storeDates(s, p.Title, dates, len(body)); err != nil {
+		return err
+	}
 
 	tagsfile := getWikiTagsFilename(p.Title)
+	pubfile := getWikiPubFilename(p.Title)
+	if frontMatterMode && !p.Encrypted {
+		// Everything is in the page so the sidecars would only get stale
+		for _, f := range []string{tagsfile, pubfile} {
+			if err := s.deleteFile(f); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
 	if err := s.storeFile(tagsfile, []byte(p.Tags)); err != nil {
 		return err
 	}
 
-	pubfile := getWikiPubFilename(p.Title)
 	if p.Published {
 		if err := s.storeFile(pubfile, nil); err != nil {
 			return err
@@ -106,46 +142,92 @@ func (p *wikiPage) save(s storage) error {
 	return nil
 }
 
+// markdownExtensions are the blackfriday extensions pages are rendered with
+const markdownExtensions = bf.CommonExtensions |
+	bf.HardLineBreak |
+	bf.HeadingIDs |
+	bf.AutoHeadingIDs
+
+// markdownPolicy is what's allowed through from rendered markdown
+func markdownPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile("^language-[a-zA-Z0-9]+$")).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile("^toc$")).OnElements("div")
+	return p
+}
+
 func convertMarkdown(page *wikiPage, err error) (*wikiPage, error) {
 	if err != nil {
 		return page, err
 	}
 
-	p := bluemonday.UGCPolicy()
-	p.AllowAttrs("class").Matching(regexp.MustCompile("^language-[a-zA-Z0-9]+$")).OnElements("code")
-
 	page.Body = template.HTML(regexp.MustCompile("\r\n").ReplaceAllString(string(page.Body), "\n"))
 
-	unsafe := bf.Run([]byte(page.Body),
-		bf.WithExtensions(
-			bf.CommonExtensions|
-				bf.HardLineBreak|
-				bf.HeadingIDs|
-				bf.AutoHeadingIDs,
-		),
-	)
+	unsafe, toc, inline := renderMarkdown([]byte(page.Body))
+	page.Index, page.InlineTOC = toc, inline
 
-	page.Body = template.HTML(p.SanitizeBytes(unsafe))
+	page.Body = template.HTML(markdownPolicy().SanitizeBytes(unsafe))
 	return page, nil
 }
 
+// renderPage turns a page's markdown into the HTML it is viewed as, which is
+// also what the editor's preview shows
+func renderPage(s storage, p *wikiPage) *wikiPage {
+	headings := pageHeadings(string(p.Body))
+	p, _ = convertMarkdown(p, nil)
+	body := sectionEditLinks([]byte(p.Body), p.Title, headings)
+	body = transclude(s, body, false, []string{p.Title})
+	p.Body = template.HTML(parseWikiWords(body, p.Title, newLinkResolver(s)))
+	return p
+}
+
 func viewHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
-	p, err := convertMarkdown(s.getPage(p))
+	p, err := s.getPage(p)
 	if err != nil {
 		p, err = s.checkForPDF(p)
 		if err != nil {
+			if to := s.getAlias(p.Title); to != "" {
+				http.Redirect(w, r, "/wiki/view/"+to, http.StatusMovedPermanently)
+				return
+			}
 			http.Redirect(w, r, "/wiki/edit/"+p.Title, http.StatusFound)
 			return
 		}
 	} else {
-		p.Body = template.HTML(parseWikiWords([]byte(p.Body)))
+		p = renderPage(s, p)
+	}
+	if li, ok := s.(linkIndexer); ok && li.hasLinkIndex() {
+		p.Backlinks = s.getBacklinks(p.Title)
 	}
+	p.Aliases = s.getAliases(p.Title)
+	p.Journal = newJournalNav(s, p.Title)
 
 	renderTemplate(w, "view", p)
 }
 
+// linkIndexer is storage that keeps an index of the links between pages.
+// Without one, backlinks mean reading every page which is too much to do on
+// every view.
+type linkIndexer interface {
+	hasLinkIndex() bool
+}
+
 func editHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
-	p, _ = s.getPage(p)
+	p, err := s.getPage(p)
+	if os.IsNotExist(err) {
+		p.fromTemplate(s, r.URL.Query().Get("template"), time.Now())
+	}
+	p.Aliases = s.getAliases(p.Title)
+	if id := r.URL.Query().Get("section"); id != "" {
+		section, ok := pageSection(string(p.Body), id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		p.Body = template.HTML(section)
+		p.Section = id
+	}
+	p.Draft = newerDraft(p)
 	renderTemplate(w, "edit", p)
 }
 
@@ -157,8 +239,12 @@ func makeSearchHandler(fn navFunc, s storage) http.HandlerFunc {
 			return
 		}
 
-		results := ParseQueryResults(s.searchPages(wikiDir, term))
-		p := &searchPage{Results: results, basePage: basePage{Title: "Search", Nav: fn(s)}}
+		p := &searchPage{Query: term, basePage: basePage{Title: "Search", Nav: fn(s)}}
+		results, _, err := s.queryPages(term, 0, 0)
+		if err != nil {
+			p.Error = err.Error()
+		}
+		p.Results = results
 
 		renderTemplate(w, "search", p)
 	}
@@ -181,54 +267,80 @@ func saveHandler(w http.ResponseWriter, r *http.Request, wiki string, s storage)
 	if r.FormValue("wikicrypt") == "on" {
 		p.Encrypted = true
 	}
+	p.Author = requestAuthor(r)
+	defer lockPage(wiki)()
+
+	// A section is spliced back into the page as it is stored now, as long
+	// as that is still the version the section was taken from
+	if id := r.FormValue("section"); id != "" {
+		current, err := s.getPage(&wikiPage{basePage: basePage{Title: wiki}})
+		if err != nil {
+			http.NotFound(w, r)
+			return ""
+		}
+		edited, ok := current.withSection(id, body)
+		if !ok {
+			http.Error(w, "The section being edited is no longer on the page", http.StatusConflict)
+			return ""
+		}
+		if current.Version != r.FormValue("version") {
+			conflictHandler(w, edited, current)
+			return ""
+		}
+		edited.Author = p.Author
+		p = *edited
+	}
+
+	// Forms that say which version they were based on get checked against
+	// what is stored now rather than silently overwriting someone else
+	if version, ok := r.Form["version"]; ok {
+		if current, same := checkVersion(s, wiki, version[0]); !same {
+			conflictHandler(w, &p, current)
+			return ""
+		}
+	}
+
+	// Only the edit form sends aliases, other saves leave them be.  They are
+	// set first so they make it into the page's front matter.
+	if _, ok := r.Form["aliases"]; ok {
+		aliases, err := parseAliases(s, wiki, r.FormValue("aliases"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return ""
+		}
+		if err := setAliases(s, wiki, aliases); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return ""
+		}
+	}
+
+	// The form doesn't cover the rest of the front matter so keep it
+	if current, err := s.getPage(&wikiPage{basePage: basePage{Title: wiki}}); err == nil {
+		p.Created = current.Created
+		p.Meta = current.Meta
+	}
 
 	if err := p.save(s); err != nil {
 		log.Printf("Error saving wiki page: %v", err) // Add logging here
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return ""
 	}
+	if err := discardDraft(p.Title); err != nil {
+		log.Printf("Error discarding draft of %v: %v", p.Title, err)
+	}
 	http.Redirect(w, r, "/wiki/view/"+p.Title, http.StatusFound)
 
 	return r.FormValue("wikitags")
 }
 
 func deleteHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
-	filename := getWikiFilename(wikiDir, p.Title)
-
-	if err := s.deleteFile(filename); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	tagsfile := getWikiTagsFilename(p.Title)
-	if err := s.deleteFile(tagsfile); err != nil && !os.IsNotExist(err) {
+	if _, err := moveToTrash(s, p.Title); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	http.Redirect(w, r, "/wiki", http.StatusFound)
 }
 
-func moveHandler(w http.ResponseWriter, r *http.Request, p *wikiPage, s storage) {
-	from := getWikiFilename(wikiDir, p.Title)
-	to := r.FormValue("to")
-	if to == "" {
-		http.Error(w, "Form param 'to' needs setting", http.StatusBadRequest)
-		return
-	}
-	tofile := getWikiFilename(wikiDir, to)
-
-	if err := s.moveFile(from, tofile); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	tagsfile := getWikiTagsFilename(p.Title)
-	totags := getWikiTagsFilename(to)
-	if err := s.moveFile(tagsfile, totags); err != nil && !os.IsNotExist(err) {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	http.Redirect(w, r, "/wiki/view/"+to, http.StatusFound)
-}
-
 func scrapeHandler(w http.ResponseWriter, r *http.Request, mdc mdConverter, st storage) {
 	url := r.FormValue("url")
 	name := r.FormValue("target")
@@ -262,7 +374,15 @@ var templates = template.Must(template.ParseFiles(
 	"views/index.html",
 	"views/footer.html",
 	"views/recents.html",
-	"views/leftnav.html"))
+	"views/leftnav.html",
+	"views/history.html",
+	"views/diff.html",
+	"views/conflict.html",
+	"views/move.html",
+	"views/links.html",
+	"views/trash.html",
+	"views/toc.html",
+	"views/journal.html"))
 
 func renderTemplate(w http.ResponseWriter, tmpl string, p interface{}) {
 	if err := templates.ExecuteTemplate(w, tmpl+".html", p); err != nil {
@@ -270,7 +390,21 @@ func renderTemplate(w http.ResponseWriter, tmpl string, p interface{}) {
 	}
 }
 
-var validPath = regexp.MustCompile(`^/wiki/(edit|save|view|search|delete|move|scrape)/([a-zA-Z0-9\.\-_ /]*)$`)
+var validPath = regexp.MustCompile(`^/wiki/(edit|save|view|search|delete|move|scrape|history|diff|restore|report|trash|journal)/([a-zA-Z0-9\.\-_ /]*)$`)
+
+// validTitle checks a page name given in a form or query is one validPath
+// would accept and that it stays inside the wiki folder
+func validTitle(title string) bool {
+	if !validPath.MatchString("/wiki/view/" + title) {
+		return false
+	}
+	for _, part := range strings.Split(title, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
 
 func makeHandler(fn func(http.ResponseWriter, *http.Request, *wikiPage, storage), navfn navFunc, s storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -305,9 +439,35 @@ func processSave(fn func(http.ResponseWriter, *http.Request, string, storage) st
 	}
 }
 
-func parseWikiWords(target []byte) []byte {
-	var wikiWord = regexp.MustCompile(`\{\{([^\}^#]+)[#]*(.*)\}\}`)
-	return wikiWord.ReplaceAll(target, []byte("<a href=\"/wiki/view/$1#$2\">$1</a>"))
+// parseWikiWords turns {{Page}} and {{Page#heading}} on a page into
+// links, other than in code and the placeholders on templates.  Given a
+// resolver, links to pages that don't exist go to the edit page so they can
+// be created, and they and links to missing headings are marked with a class.
+func parseWikiWords(target []byte, page string, lr *linkResolver) []byte {
+	return outsideCode(target, func(html []byte) []byte {
+		return linkWikiWords(html, page, lr)
+	})
+}
+
+func linkWikiWords(target []byte, page string, lr *linkResolver) []byte {
+	return wikiWordRe.ReplaceAllFunc(target, func(link []byte) []byte {
+		if isPlaceholder(page, string(link)) {
+			return link
+		}
+		if lr == nil {
+			return wikiWordRe.ReplaceAll(link, []byte("<a href=\"/wiki/view/$1#$2\">$1</a>"))
+		}
+		m := wikiWordRe.FindSubmatch(link)
+		name, fragment := string(m[1]), string(m[2])
+		title := lr.resolve(strings.TrimSpace(name))
+		switch {
+		case title == "":
+			return []byte(fmt.Sprintf(`<a class="wikilink-missing" href="/wiki/edit/%s" title="%s doesn't exist yet, click to create it">%s</a>`, name, name, name))
+		case fragment != "" && !lr.hasHeading(title, fragment):
+			return []byte(fmt.Sprintf(`<a class="wikilink-missing-heading" href="/wiki/view/%s#%s" title="%s has no heading %s">%s</a>`, name, fragment, name, fragment, name))
+		}
+		return []byte(fmt.Sprintf(`<a href="/wiki/view/%s#%s">%s</a>`, name, fragment, name))
+	})
 }
 
 func loggingHandler(next http.Handler) http.Handler {
@@ -319,7 +479,7 @@ func loggingHandler(next http.Handler) http.Handler {
 }
 
 func main() {
-	specialDir = []string{"tags", "pub"}
+	specialDir = []string{"tags", "pub", "history", "aliases", "trash", "meta", "drafts"}
 	config, err := LoadConfig()
 	checkErr(err)
 
@@ -333,31 +493,55 @@ func main() {
 	wikiDir = strings.TrimSuffix(config.WikiDir, "/") + "/"
 	tagDir = wikiDir + "tags/"
 	pubDir = wikiDir + "pub/"
+	histDir = wikiDir + "history/"
+	aliasDir = wikiDir + "aliases/"
+	trashDir = wikiDir + "trash/"
 	ekey = []byte(config.EncryptionKey)
+	frontMatterMode = config.Metadata == "frontmatter"
+	authorHeader = config.AuthorHeader
+	journalPath = config.JournalPath
+	journalTemplate = config.JournalTemplate
 
 	os.MkdirAll(tagDir, 0755)
 	os.MkdirAll(pubDir, 0755)
 
 	httpmux := http.NewServeMux()
-	
-	// Option 1: Using original cached storage
-	cached := newCachedStorage(fileStorage{tagDir}, wikiDir, tagDir)
-	fstore := &cached
-	
-	// Option 2: Using new configurable storage wrapped with caching (currently commented out)
-	/*
-	storageConfig := StorageConfig{
-		WikiDir: wikiDir,
-		TagDir:  tagDir,
-		PubDir:  pubDir,
-		EncKey:  ekey,
-	}
-	configStore := NewConfigurableStorage(storageConfig)
-	// Wrap in cached storage to maintain caching functionality
-	cached := newCachedStorage(configStore.fs, configStore.config.WikiDir, configStore.config.TagDir)
-	fstore := &cached
-	*/
-	
+
+	var fstore storage = &fileStorage{tagDir}
+	if config.Storage == "git" {
+		gs, err := newGitStorage(fileStorage{tagDir}, wikiDir, config.GitRemote)
+		checkErr(err)
+		fstore = gs
+	}
+
+	// wiki migrate frontmatter|sidecar moves every page's metadata over
+	// and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if len(os.Args) != 3 {
+			log.Fatal("Usage: wiki migrate frontmatter|sidecar")
+		}
+		n, err := migrateMetadata(fstore, os.Args[2])
+		checkErr(err)
+		log.Printf("Migrated %v pages to %v", n, os.Args[2])
+		return
+	}
+	if config.Cache {
+		cached := newCachedStorage(fstore, wikiDir, tagDir)
+		cached.enableSearch(wikiDir + ".index/search.gob")
+		fstore = &cached
+
+		// Pick up pages added or removed outside the app, e.g. via Dropbox
+		if config.Watch != "off" {
+			watcher := newWikiWatcher(wikiDir, 2*time.Second, cached.applyExternal)
+			watcher.start(config.Watch, time.Duration(config.PollInterval)*time.Second)
+			defer watcher.close()
+		}
+	}
+
+	if config.TrashDays > 0 {
+		go keepTrashTidy(fstore, config.TrashDays)
+	}
+
 	htmltomd := md.NewConverter("", true, nil)
 
 	httpmux.Handle("/wiki", loggingHandler(simpleHandler("home", getNav, fstore)))
@@ -368,6 +552,13 @@ func main() {
 	httpmux.Handle("/wiki/save/", loggingHandler(processSave(saveHandler, fstore)))
 	httpmux.Handle("/wiki/delete/", loggingHandler(makeHandler(deleteHandler, getNav, fstore)))
 	httpmux.Handle("/wiki/move/", loggingHandler(makeHandler(moveHandler, getNav, fstore)))
+	httpmux.Handle("/wiki/history/", loggingHandler(makeHandler(historyHandler, getNav, fstore)))
+	httpmux.Handle("/wiki/diff/", loggingHandler(makeHandler(diffHandler, getNav, fstore)))
+	httpmux.Handle("/wiki/restore/", loggingHandler(makeHandler(restoreHandler, getNav, fstore)))
+	httpmux.Handle("/wiki/report/", loggingHandler(makeHandler(reportHandler, getNav, fstore)))
+	httpmux.Handle("/wiki/trash/", loggingHandler(makeHandler(trashHandler, getNav, fstore)))
+	httpmux.Handle("/wiki/journal/", loggingHandler(makeHandler(journalHandler, getNav, fstore)))
+	httpmux.Handle("/wiki/today", loggingHandler(http.HandlerFunc(todayHandler)))
 	httpmux.Handle("/wiki/scrape/", loggingHandler(makeScrapeHandler(scrapeHandler, htmltomd, fstore)))
 	httpmux.Handle("/wiki/raw/", http.StripPrefix("/wiki/raw/", http.FileServer(http.Dir(wikiDir))))
 	httpmux.Handle("/pub/", loggingHandler(makePubHandler(pubHandler, getNav, fstore)))
@@ -15,11 +15,11 @@ var ParseData = []struct {
 	source string
 	res    QueryResults
 }{
-	{"wiki\t12\tsometext", QueryResults{"wiki", "12", "sometext"}},
-	{"wiki\t12\tsometext#123", QueryResults{"wiki", "12", "sometext#123"}},
-	{"wiki\t12\tsometext\tfred", QueryResults{"wiki", "12", "sometext\tfred"}},
-	{"wiki\t12", QueryResults{"wiki", "12", ""}},
-	{"wiki", QueryResults{"ERROR", "", "Invalid query result"}},
+	{"wiki\t12\tsometext", QueryResults{WikiName: "wiki", LineNum: "12", Text: "sometext"}},
+	{"wiki\t12\tsometext#123", QueryResults{WikiName: "wiki", LineNum: "12", Text: "sometext#123"}},
+	{"wiki\t12\tsometext\tfred", QueryResults{WikiName: "wiki", LineNum: "12", Text: "sometext\tfred"}},
+	{"wiki\t12", QueryResults{WikiName: "wiki", LineNum: "12"}},
+	{"wiki", QueryResults{WikiName: "ERROR", Text: "Invalid query result"}},
 }
 
 func TestParseQueryResults(t *testing.T) {
@@ -105,14 +105,18 @@ func TestSearchHandler(t *testing.T) {
 }
 
 func TestDeleteHandler(t *testing.T) {
-	called := 0
+	calls := map[string]int{}
 	stubrec := func(f string) {
-		if f != "deleteFile" {
-			t.Fatalf("Expected deleteFile but got: %v", f)
-		}
-		called++
+		calls[f]++
+	}
+	meta := ""
+	s := stubStorage{
+		loggerFunc: stubrec,
+		storeFileFunc: func(name string, content []byte) error {
+			meta = name
+			return nil
+		},
 	}
-	s := stubStorage{loggerFunc: stubrec}
 	p := wikiPage{basePage: basePage{Title: "test"}}
 	req := httptest.NewRequest("POST", "http://localhost/wiki/delete/test", nil)
 	w := httptest.NewRecorder()
@@ -124,20 +128,37 @@ func TestDeleteHandler(t *testing.T) {
 	if resp.StatusCode != http.StatusFound {
 		t.Errorf("Failed to get a 302 response, got %v", resp.StatusCode)
 	}
-	if called != 2 {
-		t.Errorf("Expected delete to be called %v but was called %v", 2, called)
+	if calls["deleteFile"] != 0 {
+		t.Errorf("Expected nothing to be deleted but deleteFile was called %v times", calls["deleteFile"])
+	}
+	// Everything but the history, which is moved on disk
+	if calls["moveFile"] != 5 {
+		t.Errorf("Expected move to be called %v but was called %v", 5, calls["moveFile"])
+	}
+	if !strings.HasPrefix(meta, trashDir) || !strings.HasSuffix(meta, "/trash.json") {
+		t.Errorf("Expected the trash details to be stored but got %q", meta)
 	}
 }
 
 func TestMoveHandler(t *testing.T) {
 	called := 0
 	stubrec := func(f string) {
-		if f != "moveFile" {
-			t.Fatalf("Expected moveFile but got: %v", f)
+		if f == "moveFile" {
+			called++
 		}
-		called++
 	}
-	s := stubStorage{loggerFunc: stubrec}
+	aliased := ""
+	s := stubStorage{
+		loggerFunc: stubrec,
+		getPageFunc: func(p *wikiPage) (*wikiPage, error) {
+			p.Body = "no images here"
+			return p, nil
+		},
+		storeFileFunc: func(name string, content []byte) error {
+			aliased = string(content)
+			return nil
+		},
+	}
 	p := wikiPage{basePage: basePage{Title: "test"}}
 	form := url.Values{}
 	form.Add("to", "newtest")
@@ -160,8 +181,12 @@ func TestMoveHandler(t *testing.T) {
 	if url.Path != "/wiki/view/newtest" {
 		t.Errorf("Expected /wiki/view/newtest but got %v from 302", url.Path)
 	}
-	if called != 2 {
-		t.Errorf("Expected storage  to be called %v but was called %v", 2, called)
+	// The page, tags, pub marker, dates and images, history is moved on disk
+	if called != 5 {
+		t.Errorf("Expected storage  to be called %v but was called %v", 5, called)
+	}
+	if aliased != "newtest" {
+		t.Errorf("Expected an alias to newtest but got %v", aliased)
 	}
 }
 
@@ -184,7 +209,7 @@ func TestScrapeHandler(t *testing.T) {
 	stubConverter := mdc{}
 	stubStore := stubStorage{
 		storeFileFunc: func(name string, content []byte) error {
-			if name != "test" && name != "test.md" {
+			if name != "test" && name != "test.md" && name != "meta/test" {
 				t.Errorf("expecting %v but got %v", "test", name)
 			}
 			return nil